@@ -0,0 +1,188 @@
+package lotf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	COMMAND_MIN_BACKOFF  = time.Second
+	COMMAND_MAX_BACKOFF  = time.Minute
+	COMMAND_KILL_TIMEOUT = 5 * time.Second
+)
+
+// CommandSource is Source which runs a command and yields lines of its stdout
+// and stderr. The command is restarted when it exits, after backoff time which
+// starts from MinBackoff and doubles up to MaxBackoff while the command exits
+// sooner than MaxBackoff. Close sends SIGTERM to the command, and SIGKILL if
+// it does not exit in KillTimeout.
+type CommandSource struct {
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	KillTimeout time.Duration
+	args        []string
+	mu          sync.Mutex // to sync ingest, cmd, exited and closed
+	cmd         *exec.Cmd
+	exited      chan bool // closed when cmd exited
+	closed      bool
+	done        chan bool
+	offset      int64 // sum of line length, as if they are in a file
+}
+
+// NewCommandSource creates a source which runs args[0] with args[1:].
+func NewCommandSource(args []string) (*CommandSource, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		if glog.V(1) {
+			glog.Infof("exec.LookPath(%s): %s", args[0], err)
+		}
+		return nil, err
+	}
+	return &CommandSource{
+		MinBackoff:  COMMAND_MIN_BACKOFF,
+		MaxBackoff:  COMMAND_MAX_BACKOFF,
+		KillTimeout: COMMAND_KILL_TIMEOUT,
+		args:        args,
+		done:        make(chan bool),
+	}, nil
+}
+
+// Name returns args joined by a space.
+func (s *CommandSource) Name() string {
+	return strings.Join(s.args, " ")
+}
+
+// reads lines from r until EOF
+func (s *CommandSource) readlines(r io.Reader, ingest func(string, int64), wg *sync.WaitGroup) {
+	defer wg.Done()
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			text := strings.TrimSuffix(string(line), "\n")
+			s.mu.Lock()
+			offset := s.offset
+			s.offset += int64(len(line))
+			s.mu.Unlock()
+			ingest(text, offset)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runs the command once and waits for its exit
+func (s *CommandSource) runOnce(ingest func(string, int64)) error {
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // to kill children too
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	if err = cmd.Start(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.cmd = cmd
+	s.exited = make(chan bool)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go s.readlines(stdout, ingest, &wg)
+	go s.readlines(stderr, ingest, &wg)
+	wg.Wait()
+	err = cmd.Wait()
+
+	s.mu.Lock()
+	s.cmd = nil
+	close(s.exited)
+	s.mu.Unlock()
+	if err == nil {
+		return fmt.Errorf("exited: %s", s.Name())
+	}
+	return fmt.Errorf("exited: %s: %s", s.Name(), err)
+}
+
+func (s *CommandSource) Run(ingest func(string, int64), warn func(string, error)) error {
+	backoff := s.MinBackoff
+	for {
+		started := time.Now()
+		err := s.runOnce(ingest)
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+		warn("exec", err)
+
+		if time.Since(started) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+		if glog.V(1) {
+			glog.Infof("restarting in %s: %s", backoff, s.Name())
+		}
+		select {
+		case <-s.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+func (s *CommandSource) consumed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset
+}
+
+// Close terminates the running command and stops restarting. The command is
+// killed if it does not exit in KillTimeout after SIGTERM.
+func (s *CommandSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	if s.cmd == nil {
+		return nil
+	}
+	pgid, exited := -s.cmd.Process.Pid, s.exited
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(s.KillTimeout):
+			if glog.V(1) {
+				glog.Infof("killing, not exited by SIGTERM: %s", s.Name())
+			}
+			syscall.Kill(pgid, syscall.SIGKILL)
+		}
+	}()
+	return syscall.Kill(pgid, syscall.SIGTERM)
+}
@@ -0,0 +1,97 @@
+package lotf
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommandSource(t *testing.T) {
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	restarted := make(chan bool, 8)
+	go func() {
+		for err := range tw.Error {
+			var terr *TailError
+			if !errors.As(err, &terr) || terr.Severity != SEVERITY_WARNING || terr.Op != "exec" {
+				t.Errorf("unexpected error received: %s", err)
+				continue
+			}
+			select {
+			case restarted <- true:
+			default:
+			}
+		}
+	}()
+
+	if _, err := NewCommandSource([]string{"/no/such/command"}); err == nil {
+		t.Fatalf("expect error for no such command")
+	}
+	src, err := NewCommandSource([]string{"sh", "-c", "echo out; echo err >&2; printf last"})
+	if err != nil {
+		t.Fatalf("NewCommandSource failed: %s", err)
+	}
+	src.MinBackoff = 10 * time.Millisecond
+	src.MaxBackoff = 20 * time.Millisecond
+	tail, err := tw.AddSource(src, 16, nil)
+	if err != nil {
+		t.Fatalf("AddSource failed: %s", err)
+	}
+
+	// twice, restarted
+	for i := 0; i < 2; i++ {
+		seen := make(map[string]bool)
+		for len(seen) < 3 {
+			line := tail.WaitNextLine()
+			if line == nil {
+				t.Fatalf("unexpected end of lines")
+			}
+			seen[line.Text] = true
+		}
+		if !seen["out"] || !seen["err"] || !seen["last"] {
+			t.Fatalf("unexpected lines: %v", seen)
+		}
+		<-restarted
+	}
+
+	if err := tw.Remove(src.Name()); err != nil {
+		t.Fatalf("Remove failed: %s", err)
+	}
+	for line := tail.WaitNextLine(); line != nil; line = tail.WaitNextLine() {
+	}
+}
+
+func TestCommandSourceKill(t *testing.T) {
+	src, err := NewCommandSource([]string{"sh", "-c", `trap "" TERM; echo ready; while :; do sleep 1; done`})
+	if err != nil {
+		t.Fatalf("NewCommandSource failed: %s", err)
+	}
+	src.KillTimeout = 100 * time.Millisecond
+	ready := make(chan bool, 1)
+	done := make(chan error)
+	go func() {
+		done <- src.Run(func(string, int64) {
+			select {
+			case ready <- true:
+			default:
+			}
+		}, func(string, error) {})
+	}()
+	<-ready
+
+	// SIGTERM is ignored
+	if err := src.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned: %s", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("the command is not killed")
+	}
+}
@@ -0,0 +1,141 @@
+package lotf
+
+import (
+	"os"
+)
+
+// contextLines selects lines around the ones filter accepted like grep -B and
+// -A, see TailOptions.Before. It keeps the state of the tail fed.
+type contextLines struct {
+	before, after int
+	prev          []*Line // last lines filter rejected, up to before
+	remain        int     // lines to store after the last match
+	seq           int64   // sequence number of the line fed last
+	last          int64   // seq of the line stored last, 0 if none
+}
+
+// returns nil if both of before and after are 0
+func newContextLines(before, after int) *contextLines {
+	if before <= 0 && after <= 0 {
+		return nil
+	}
+	if before < 0 {
+		before = 0
+	}
+	if after < 0 {
+		after = 0
+	}
+	return &contextLines{before: before, after: after}
+}
+
+// returns a contextLines of the same numbers with no state
+func (c *contextLines) fresh() *contextLines {
+	return &contextLines{before: c.before, after: c.after}
+}
+
+// feeds the next line and stores it, or with the lines before it if match, to
+// q by dedup and mapper.
+func (c *contextLines) feed(q *Blockq, dedup *Dedup, mapper Mapper, line *Line, match bool) {
+	c.seq++
+	switch {
+	case match:
+		seq := c.seq - int64(len(c.prev))
+		for _, l := range c.prev {
+			c.store(q, dedup, mapper, l, seq)
+			seq++
+		}
+		c.prev = c.prev[:0]
+		c.store(q, dedup, mapper, line, c.seq)
+		c.remain = c.after
+	case c.remain > 0:
+		c.store(q, dedup, mapper, line, c.seq)
+		c.remain--
+	case c.before > 0:
+		if len(c.prev) == c.before {
+			c.prev = append(c.prev[:0], c.prev[1:]...)
+		}
+		c.prev = append(c.prev, line)
+	}
+}
+
+// stores a separator marker before line if it is not next to the last one
+func (c *contextLines) store(q *Blockq, dedup *Dedup, mapper Mapper, line *Line, seq int64) {
+	if c.last > 0 && seq != c.last+1 {
+		q.Add(&Line{Marker: &Marker{Type: MARKER_SEPARATOR}})
+	}
+	c.last = seq
+	storeLine(q, dedup, mapper, line)
+}
+
+// breaks the adjacency by n lines not fed, or by rotation
+func (c *contextLines) skip(n int64) {
+	c.seq += n
+	c.prev = c.prev[:0]
+	c.remain = 0
+}
+
+// a line read backward by lastContextLines
+type backLine struct {
+	line  *Line
+	match bool
+	read  int64 // 1 for the last line
+}
+
+// lastLines with the context of c, which is fed the last lines which filter
+// accepts and lines around them in order. Lines not required as context are
+// not kept while reading backward.
+func lastContextLines(file *os.File, lines int, q *Blockq, filter Filter, c *contextLines) (int64, error) {
+	var got, pending []backLine // in the order read
+	var reads, lastMatch int64  // lastMatch is reads of the last match read
+	n := 0
+	pos, err := walkBackward(file, func(text string, offset int64) bool {
+		if lines <= 0 {
+			return false
+		}
+		reads++
+		l := backLine{&Line{Text: text, Offset: offset}, false, reads}
+		if n >= lines { // before the earliest match
+			got = append(got, l)
+			return reads-lastMatch < int64(c.before)
+		}
+		l.match, l.line.Match = matchLine(filter, text)
+		switch {
+		case l.match:
+			n++
+			// pending ones are after this, keeps as many as required
+			if len(pending) > c.after {
+				pending = pending[len(pending)-c.after:]
+			}
+			got = append(got, pending...)
+			got = append(got, l)
+			pending = pending[:0]
+			lastMatch = reads
+		case lastMatch > 0 && reads-lastMatch <= int64(c.before):
+			got = append(got, l)
+		default:
+			if len(pending) > 0 && len(pending) >= c.after {
+				pending = pending[1:]
+			}
+			if c.after > 0 {
+				pending = append(pending, l)
+			}
+		}
+		return n < lines || c.before > 0
+	})
+	if err != nil {
+		return pos, err
+	}
+
+	next := reads + 1 // read number of the next line fed
+	for i := len(got) - 1; i >= 0; i-- {
+		if gap := next - got[i].read - 1; gap > 0 && next <= reads {
+			c.skip(gap)
+		}
+		c.feed(q, nil, nil, got[i].line, got[i].match)
+		next = got[i].read
+	}
+	if next > 1 { // lines after the last one fed are not required
+		c.skip(next - 1)
+	}
+	return pos, nil
+}
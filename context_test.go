@@ -0,0 +1,89 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextLines(t *testing.T) {
+	for _, c := range []struct {
+		before, after int
+		lines         string
+		expect        string
+	}{
+		{1, 1, "a x1 b c d x2 e x3 f g", "a x1 b -- d x2 e x3 f"},
+		{0, 1, "x1 a x2 b c", "x1 a x2 b"},
+		{2, 0, "a b c x1 x2 d", "b c x1 x2"},
+	} {
+		q, _ := NewBlockq(100)
+		ctx := newContextLines(c.before, c.after)
+		for i, s := range strings.Fields(c.lines) {
+			ctx.feed(q, nil, nil, &Line{Text: s, Offset: int64(i)}, strings.HasPrefix(s, "x"))
+		}
+		var got []string
+		for e := q.Head(); e != nil; e = e.Next() {
+			got = append(got, e.Value.(*Line).String())
+		}
+		if strings.Join(got, " ") != c.expect {
+			t.Fatalf("lines: %q, expect: %q, but got: %q", c.lines, c.expect, got)
+		}
+	}
+
+	if newContextLines(0, 0) != nil {
+		t.Fatalf("expect nil with no context")
+	}
+}
+
+func TestContextTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a\nx1\nb\nc\nd\nx2\ne\nf\ng\nh\nx3\ni\nj\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	// the last 2 matches with their context, walking backward
+	tail, err := tw.AddOptions(fname, 100, substrFilter("x"), 2, &TailOptions{Before: 1, After: 1})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	for _, s := range []string{"d", "x2", "e", "--", "h", "x3", "i"} {
+		if line := tail.NextLine(); line == nil || line.String() != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+	if line := tail.NextLine(); line != nil {
+		t.Fatalf("expect no more line, but got: %v", line)
+	}
+
+	// j is not adjacent to i
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("k\nx4\nl\nm\n")
+	wfile.Close()
+	for _, s := range []string{"--", "k", "x4", "l"} {
+		if line := tail.WaitNextLine(); line == nil || line.String() != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package lotf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEDUP_SUMMARY is the format of the line stored when a run of repeated lines
+// ends, like syslogd.
+const DEDUP_SUMMARY = "last message repeated %d times"
+
+// hex with 0x, hex words containing a digit, then the rest of digits
+var dedupMaskExp = regexp.MustCompile(`0[xX][0-9a-fA-F]+|\b[0-9a-fA-F]*[0-9][0-9a-fA-F]*\b|[0-9]+`)
+
+// Dedup collapses consecutive lines which are the same into the first one and
+// a summary line of DEDUP_SUMMARY, see TailOptions.Dedup. It keeps the state of
+// a run so that it must not be shared among tails. With window, the summary is
+// stored when the window expires even if no line follows the repeats.
+type Dedup struct {
+	window time.Duration    // a repeat after this from the run start starts a new run
+	mask   bool             // compares lines with numbers masked
+	now    func() time.Time // time.Now, replaced in test
+	quiet  bool             // arms no timer, to rebuild lines by Refilter
+
+	mu     sync.Mutex  // to sync the run with timer
+	key    string      // the first line of the run, masked if mask
+	start  time.Time   // when the run started
+	count  int         // lines dropped in the run
+	valid  bool        // key is set
+	offset int64       // of the last line dropped
+	run    int         // incremented when a run ends, to tell timer is stale
+	timer  *time.Timer // ends the run when window expires, nil if not armed
+}
+
+// NewDedup creates Dedup from spec, comma separated options of:
+//
+//	on            compares lines as they are with no window, the default
+//	mask          compares lines with digits and hex numbers masked
+//	window=<dur>  a repeat after dur from the first line starts a new run
+func NewDedup(spec string) (*Dedup, error) {
+	d := &Dedup{now: time.Now}
+	for _, opt := range strings.Split(spec, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "" || opt == "on":
+		case opt == "mask":
+			d.mask = true
+		case strings.HasPrefix(opt, "window="):
+			window, err := time.ParseDuration(opt[len("window="):])
+			if err != nil {
+				return nil, err
+			}
+			if window < 0 {
+				return nil, fmt.Errorf("negative dedup window: %s", opt)
+			}
+			d.window = window
+		default:
+			return nil, fmt.Errorf("unknown dedup option: %s", opt)
+		}
+	}
+	return d, nil
+}
+
+// returns a Dedup of the same options with no run and no timer
+func (d *Dedup) fresh() *Dedup {
+	return &Dedup{window: d.window, mask: d.mask, now: d.now, quiet: true}
+}
+
+func (d *Dedup) keyOf(line string) string {
+	if d.mask {
+		return dedupMaskExp.ReplaceAllLiteralString(line, "#")
+	}
+	return line
+}
+
+// returns whether line should be stored, and the summary of the run line ended
+// which should be stored before it, empty if none. The summary is stored to q
+// when the window expires instead.
+func (d *Dedup) check(line *Line, q *Blockq) (bool, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key, now := d.keyOf(line.Text), d.now()
+	if d.valid && key == d.key && (d.window == 0 || now.Sub(d.start) <= d.window) {
+		d.count++
+		d.offset = line.Offset
+		if d.count == 1 {
+			d.arm(q)
+		}
+		return false, ""
+	}
+	summary := d.end()
+	d.key, d.start, d.valid = key, now, true
+	return true, summary
+}
+
+// arms timer to store the summary to q when the window of the run expires,
+// caller must hold d.mu
+func (d *Dedup) arm(q *Blockq) {
+	if d.window == 0 || d.quiet {
+		return
+	}
+	run := d.run
+	d.timer = time.AfterFunc(d.window-d.now().Sub(d.start), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if run != d.run { // ended by a line or flush
+			return
+		}
+		offset := d.offset
+		if summary := d.end(); len(summary) > 0 {
+			q.Add(&Line{Text: summary, Offset: offset})
+		}
+	})
+}
+
+// ends the current run, e.g. on rotation, and returns its summary if any
+func (d *Dedup) flush() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.end()
+}
+
+// flush, caller must hold d.mu
+func (d *Dedup) end() string {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	count := d.count
+	d.key, d.count, d.valid = "", 0, false
+	d.run++
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(DEDUP_SUMMARY, count)
+}
+
+// takes over the run of rebuilt by Refilter, which goes on with lines ingested
+// after this if it is the same as the current one. Caller must hold d.mu.
+func (d *Dedup) takeOver(rebuilt *Dedup, q *Blockq) {
+	start := rebuilt.start
+	if d.valid && d.key == rebuilt.key {
+		start = d.start
+	}
+	d.end()
+	d.key, d.start, d.count, d.valid, d.offset = rebuilt.key, start, rebuilt.count, rebuilt.valid, rebuilt.offset
+	if d.count > 0 {
+		d.arm(q)
+	}
+}
+
+func (d *Dedup) String() string {
+	opts := []string{"on"}
+	if d.mask {
+		opts = append(opts, "mask")
+	}
+	if d.window > 0 {
+		opts = append(opts, "window="+d.window.String())
+	}
+	return strings.Join(opts, ",")
+}
+
+// stores line which filter accepted to q, collapsing repeats by dedup and
+// rewriting by mapper, either may be nil.
+func storeLine(q *Blockq, dedup *Dedup, mapper Mapper, line *Line) {
+	if dedup != nil {
+		pass, summary := dedup.check(line, q)
+		if len(summary) > 0 {
+			q.Add(&Line{Text: summary, Offset: line.Offset})
+		}
+		if !pass {
+			return
+		}
+	}
+	if mapper != nil {
+		text, match := mapLine(mapper, line)
+		line = &Line{Text: text, Offset: line.Offset, Match: match}
+	}
+	q.Add(line)
+}
@@ -0,0 +1,170 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	now := time.Unix(0, 0)
+	repeated := func(n int) string { return fmt.Sprintf(DEDUP_SUMMARY, n) }
+	for _, c := range []struct {
+		spec   string
+		lines  []string
+		expect []string
+	}{
+		{"", []string{"a", "a", "a", "b", "a", "a"}, []string{"a", repeated(2), "b", "a", repeated(1)}},
+		{"on", []string{"port 1 down", "port 2 down"}, []string{"port 1 down", "port 2 down"}},
+		{"mask", []string{"port 1 down", "port 22 down", "eth0 0xdeadbeef at 1f2e", "eth1 0x0 at cafe99"},
+			[]string{"port 1 down", repeated(1), "eth0 0xdeadbeef at 1f2e", repeated(1)}},
+		// a tick is 1s, the run starts again after the window
+		{"window=2s", []string{"a", "a", "a", "a", "a"}, []string{"a", repeated(2), "a", repeated(1)}},
+	} {
+		d, err := NewDedup(c.spec)
+		if err != nil {
+			t.Fatalf("NewDedup(%q): %s", c.spec, err)
+		}
+		d.now = func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}
+		d.quiet = true // the window is of the fake clock
+		var got []string
+		for _, line := range c.lines {
+			pass, summary := d.check(&Line{Text: line}, nil)
+			if len(summary) > 0 {
+				got = append(got, summary)
+			}
+			if pass {
+				got = append(got, line)
+			}
+		}
+		if summary := d.flush(); len(summary) > 0 {
+			got = append(got, summary)
+		}
+		if len(got) != len(c.expect) {
+			t.Fatalf("spec: %q, expect: %q, but got: %q", c.spec, c.expect, got)
+		}
+		for i, s := range c.expect {
+			if got[i] != s {
+				t.Fatalf("spec: %q, expect: %q, but got: %q", c.spec, c.expect, got)
+			}
+		}
+	}
+
+	for _, spec := range []string{"window=x", "window=-1s", "fuzzy"} {
+		if _, err := NewDedup(spec); err == nil {
+			t.Fatalf("expect error from spec: %s", spec)
+		}
+	}
+}
+
+func TestDedupTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte(""), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	dedup, _ := NewDedup("mask")
+	tail, err := tw.AddOptions(fname, 100, nil, 10, &TailOptions{Markers: true, Dedup: dedup})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("link 1 down\nlink 2 down\nlink 3 down\nup\nup\nup\n")
+	wfile.Close()
+	for _, s := range []string{"link 1 down", "last message repeated 2 times", "up"} {
+		if line := tail.WaitNextLine(); line == nil || line.String() != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+
+	// the run ends before the file is rotated
+	if err := os.Rename(fname, fname+".1"); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("up\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	for _, s := range []string{"last message repeated 2 times", "delete", "create", "rotate", "up"} {
+		line := tail.WaitNextLine()
+		if line == nil {
+			t.Fatalf("expect %s, but got nil", s)
+		}
+		if line.IsMarker() && line.Marker.Event.Type.String() != s || !line.IsMarker() && line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+}
+
+func TestDedupWindowExpires(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte(""), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+
+	dedup, _ := NewDedup("window=200ms")
+	tail, err := tw.AddOptions(fname, 100, nil, 10, &TailOptions{Dedup: dedup})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("a\na\na\n")
+	wfile.Close()
+
+	// the summary comes with no line after the repeats
+	lines := make(chan *Line)
+	go func() {
+		for i := 0; i < 2; i++ {
+			lines <- tail.WaitNextLine()
+		}
+	}()
+	for _, s := range []string{"a", fmt.Sprintf(DEDUP_SUMMARY, 2)} {
+		select {
+		case line := <-lines:
+			if line == nil || line.String() != s {
+				t.Fatalf("expect %s, but got: %v", s, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package lotf
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Severity int
+
+const (
+	SEVERITY_WARNING Severity = iota // recovered, the tail keeps working
+	SEVERITY_ERROR                   // operation failed, lines may be lost
+	SEVERITY_FATAL                   // TailWatcher can not continue
+)
+
+var severityNames = []string{
+	SEVERITY_WARNING: "warning",
+	SEVERITY_ERROR:   "error",
+	SEVERITY_FATAL:   "fatal",
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+var ErrorClosed = errors.New("lotf: watcher closed")
+var ErrorAlreadyWatching = errors.New("lotf: already watching")
+var ErrorNotWatching = errors.New("lotf: no such a watcher")
+var ErrorAlreadyOpened = errors.New("lotf: open already opened file")
+var ErrorQueueOverflow = errors.New("lotf: inotify event queue overflowed")
+var ErrorNoHistory = errors.New("lotf: no history in stream")
+var ErrorNoRawLines = errors.New("lotf: no raw lines kept")
+var ErrorIngestDiffers = errors.New("lotf: already watching with different parameters")
+
+// TailError records an error and the path and operation that caused it.
+// Errors sent to TailWatcher.Error are this type.
+type TailError struct {
+	Path     string // file absname, may be empty for watcher wide error
+	Op       string
+	Severity Severity
+	Err      error
+}
+
+func (e *TailError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s: %s", e.Severity, e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s: %s %s: %s", e.Severity, e.Op, e.Path, e.Err)
+}
+
+func (e *TailError) Unwrap() error {
+	return e.Err
+}
+
+// IsFatal reports whether err is fatal. err which is not a TailError is
+// regarded as fatal since it can not be classified.
+func IsFatal(err error) bool {
+	var te *TailError
+	if errors.As(err, &te) {
+		return te.Severity >= SEVERITY_FATAL
+	}
+	return true
+}
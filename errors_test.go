@@ -0,0 +1,43 @@
+package lotf
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestTailError(t *testing.T) {
+	te := &TailError{Path: "/tmp/a", Op: "open", Severity: SEVERITY_WARNING, Err: ErrorAlreadyOpened}
+	if !errors.Is(te, ErrorAlreadyOpened) {
+		t.Fatalf("TailError should wrap: %s", ErrorAlreadyOpened)
+	}
+	if IsFatal(te) {
+		t.Fatalf("warning should not be fatal")
+	}
+	if s := te.Error(); s != "warning: open /tmp/a: lotf: open already opened file" {
+		t.Fatalf("unexpected message: %s", s)
+	}
+
+	te = &TailError{Op: "inotify", Severity: SEVERITY_FATAL, Err: os.ErrInvalid}
+	if !IsFatal(te) {
+		t.Fatalf("fatal should be fatal")
+	}
+	if !IsFatal(errors.New("unknown")) {
+		t.Fatalf("unclassified error should be fatal")
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	err = tw.Remove("/nonexistent")
+	if !errors.Is(err, ErrorNotWatching) {
+		t.Fatalf("expect ErrorNotWatching, but got: %v", err)
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatalf("failed to Close TailWatcher: %s", err)
+	}
+	if _, err = tw.Lookup("/nonexistent"); !errors.Is(err, ErrorClosed) {
+		t.Fatalf("expect ErrorClosed, but got: %v", err)
+	}
+}
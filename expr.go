@@ -0,0 +1,695 @@
+package lotf
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// position in an expression file, 1 origin
+type exprPos struct {
+	line, col int
+}
+
+// ExprError is a syntax or type error of an expression with its position.
+type ExprError struct {
+	Path string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Col, e.Msg)
+}
+
+type exprType int
+
+const (
+	typeAny      exprType = iota // field text, converted on use
+	typeString                   // string literal or function result
+	typeNumber                   // number literal or numeric field
+	typeBool                     // result of condition
+	typeSeverity                 // syslog severity, compared with names as numbers
+)
+
+func (t exprType) String() string {
+	return [...]string{"field", "string", "number", "bool", "severity"}[t]
+}
+
+// syslog severity names, smaller is more severe
+var severityLevels = map[string]int{
+	"emerg": 0, "panic": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// a token of lexer, or a node of parsed tree
+type exprNode struct {
+	op    string // operator, or one of field, string, number, bool, call
+	pos   exprPos
+	args  []*exprNode
+	name  string // field or function name
+	str   string
+	num   float64
+	typ   exprType
+	re    *regexp.Regexp // right of =~ and !~
+	ipnet *net.IPNet     // second arg of cidr
+}
+
+type exprParser struct {
+	path   string
+	src    []rune
+	i      int
+	pos    exprPos
+	tok    *exprNode // current token, op "" at EOF
+	schema map[string]exprType
+	closed bool // schema has all fields, others are unknown
+}
+
+func (p *exprParser) errorf(pos exprPos, format string, args ...interface{}) error {
+	return &ExprError{Path: p.path, Line: pos.line, Col: pos.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) advance() {
+	if p.src[p.i] == '\n' {
+		p.pos.line++
+		p.pos.col = 1
+	} else {
+		p.pos.col++
+	}
+	p.i++
+}
+
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", ","}
+
+// reads the next token into p.tok
+func (p *exprParser) next() error {
+	for p.i < len(p.src) {
+		if p.src[p.i] == '#' { // comment to the end of line
+			for p.i < len(p.src) && p.src[p.i] != '\n' {
+				p.advance()
+			}
+		} else if unicode.IsSpace(p.src[p.i]) {
+			p.advance()
+		} else {
+			break
+		}
+	}
+	tok := &exprNode{pos: p.pos}
+	p.tok = tok
+	if p.i >= len(p.src) {
+		return nil
+	}
+
+	c := p.src[p.i]
+	start := p.i
+	switch {
+	case c == '"':
+		for p.advance(); p.i < len(p.src) && p.src[p.i] != '"'; p.advance() {
+			if p.src[p.i] == '\\' && p.i+1 < len(p.src) {
+				p.advance()
+			}
+			if p.src[p.i] == '\n' {
+				break
+			}
+		}
+		if p.i >= len(p.src) || p.src[p.i] != '"' {
+			return p.errorf(tok.pos, "unterminated string")
+		}
+		p.advance()
+		s, err := strconv.Unquote(string(p.src[start:p.i]))
+		if err != nil {
+			return p.errorf(tok.pos, "invalid string: %s", err)
+		}
+		tok.op, tok.str, tok.typ = "string", s, typeString
+	case c >= '0' && c <= '9' || c == '-' && p.i+1 < len(p.src) && p.src[p.i+1] >= '0' && p.src[p.i+1] <= '9':
+		for p.advance(); p.i < len(p.src) && strings.ContainsRune("0123456789.eE", p.src[p.i]); p.advance() {
+		}
+		n, err := strconv.ParseFloat(string(p.src[start:p.i]), 64)
+		if err != nil {
+			return p.errorf(tok.pos, "invalid number: %s", string(p.src[start:p.i]))
+		}
+		tok.op, tok.num, tok.str, tok.typ = "number", n, string(p.src[start:p.i]), typeNumber
+	case unicode.IsLetter(c) || c == '_':
+		for p.advance(); p.i < len(p.src); p.advance() {
+			c = p.src[p.i]
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' && c != '-' {
+				break
+			}
+		}
+		tok.op, tok.name = "field", string(p.src[start:p.i])
+		if tok.name == "true" || tok.name == "false" {
+			tok.op, tok.typ = "bool", typeBool
+			tok.num = map[string]float64{"true": 1, "false": 0}[tok.name]
+		}
+	default:
+		rest := string(p.src[p.i:])
+		for _, op := range exprOps {
+			if strings.HasPrefix(rest, op) {
+				tok.op = op
+				for range op {
+					p.advance()
+				}
+				return nil
+			}
+		}
+		return p.errorf(tok.pos, "unexpected character: %q", c)
+	}
+	return nil
+}
+
+func (p *exprParser) expect(op string) error {
+	if p.tok.op != op {
+		return p.errorf(p.tok.pos, "expected %s", op)
+	}
+	return p.next()
+}
+
+// expr := and ("||" and)*
+func (p *exprParser) parseOr() (*exprNode, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+// and := unary ("&&" unary)*
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	return p.parseBinary([]string{"&&"}, p.parseUnary)
+}
+
+func (p *exprParser) parseBinary(ops []string, operand func() (*exprNode, error)) (*exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.tok
+		found := false
+		for _, op := range ops {
+			found = found || tok.op == op
+		}
+		if !found {
+			return left, nil
+		}
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		tok.args = []*exprNode{left, right}
+		left = tok
+	}
+}
+
+// unary := "!" unary | cmp
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	if p.tok.op == "!" {
+		tok := p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		tok.args = []*exprNode{arg}
+		return tok, nil
+	}
+	return p.parseBinary([]string{"==", "!=", "<", "<=", ">", ">=", "=~", "!~"}, p.parsePrimary)
+}
+
+// primary := field | string | number | bool | name "(" args ")" | "(" expr ")"
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.tok
+	switch tok.op {
+	case "string", "number", "bool":
+		return tok, p.next()
+	case "field":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.op != "(" {
+			return tok, nil
+		}
+		tok.op = "call"
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for p.tok.op != ")" {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			tok.args = append(tok.args, arg)
+			if p.tok.op != "," {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		return tok, p.expect(")")
+	case "(":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "":
+		return nil, p.errorf(tok.pos, "unexpected end of expression")
+	}
+	return nil, p.errorf(tok.pos, "unexpected %s", tok.op)
+}
+
+// infers types of n and its args, and compiles literals for regexp and cidr
+func (p *exprParser) check(n *exprNode) error {
+	for _, arg := range n.args {
+		if err := p.check(arg); err != nil {
+			return err
+		}
+	}
+	switch n.op {
+	case "field":
+		typ, found := p.schema[n.name]
+		if !found && p.closed {
+			return p.errorf(n.pos, "unknown field: %s", n.name)
+		}
+		n.typ = typ
+	case "!", "&&", "||":
+		for _, arg := range n.args {
+			if arg.typ != typeBool {
+				return p.errorf(arg.pos, "%s requires bool, not %s", n.op, arg.typ)
+			}
+		}
+		n.typ = typeBool
+	case "=~", "!~":
+		left, right := n.args[0], n.args[1]
+		if left.typ == typeBool {
+			return p.errorf(left.pos, "%s requires string, not bool", n.op)
+		}
+		if right.op != "string" {
+			return p.errorf(right.pos, "%s requires string literal", n.op)
+		}
+		var err error
+		if n.re, err = regexp.Compile(right.str); err != nil {
+			return p.errorf(right.pos, "%s", err)
+		}
+		n.typ = typeBool
+	case "==", "!=", "<", "<=", ">", ">=":
+		left, right := n.args[0], n.args[1]
+		for _, pair := range [][2]*exprNode{{left, right}, {right, left}} {
+			if pair[0].typ == typeSeverity && pair[1].op == "string" {
+				level, found := severityLevels[strings.ToLower(pair[1].str)]
+				if !found {
+					return p.errorf(pair[1].pos, "unknown severity: %s", pair[1].str)
+				}
+				pair[1].op, pair[1].num, pair[1].typ = "number", float64(level), typeNumber
+			}
+		}
+		if (left.typ == typeBool) != (right.typ == typeBool) {
+			return p.errorf(n.pos, "mismatched types %s and %s", left.typ, right.typ)
+		}
+		if left.typ == typeBool && n.op != "==" && n.op != "!=" {
+			return p.errorf(n.pos, "%s on bool", n.op)
+		}
+		if left.typ == typeString && right.typ == typeNumber || left.typ == typeNumber && right.typ == typeString {
+			return p.errorf(n.pos, "mismatched types %s and %s", left.typ, right.typ)
+		}
+		n.typ = typeBool
+	case "call":
+		return p.checkCall(n)
+	}
+	return nil
+}
+
+func (p *exprParser) checkCall(n *exprNode) error {
+	arity := map[string]int{"cidr": 2, "contains": 2, "lower": 1, "exists": 1}
+	want, found := arity[n.name]
+	if !found {
+		return p.errorf(n.pos, "unknown function: %s", n.name)
+	}
+	if len(n.args) != want {
+		return p.errorf(n.pos, "%s requires %d args, not %d", n.name, want, len(n.args))
+	}
+	for _, arg := range n.args {
+		if arg.typ == typeBool {
+			return p.errorf(arg.pos, "%s requires string, not bool", n.name)
+		}
+	}
+	switch n.name {
+	case "cidr":
+		if n.args[1].op != "string" {
+			return p.errorf(n.args[1].pos, "cidr requires string literal")
+		}
+		var err error
+		if _, n.ipnet, err = net.ParseCIDR(n.args[1].str); err != nil {
+			return p.errorf(n.args[1].pos, "%s", err)
+		}
+		n.typ = typeBool
+	case "contains":
+		n.typ = typeBool
+	case "lower":
+		n.typ = typeString
+	case "exists":
+		if n.args[0].op != "field" {
+			return p.errorf(n.args[0].pos, "exists requires field")
+		}
+		n.typ = typeBool
+	}
+	return nil
+}
+
+// a value on evaluation
+type exprValue struct {
+	s       string
+	n       float64
+	isNum   bool
+	b       bool
+	missing bool
+}
+
+func (n *exprNode) eval(fields map[string]string) exprValue {
+	switch n.op {
+	case "field":
+		s, found := fields[n.name]
+		if !found {
+			return exprValue{missing: true}
+		}
+		v := exprValue{s: s}
+		v.n, v.isNum = parseNumber(s)
+		return v
+	case "string":
+		return exprValue{s: n.str}
+	case "number":
+		return exprValue{s: n.str, n: n.num, isNum: true}
+	case "bool":
+		return exprValue{b: n.num != 0}
+	case "!":
+		return exprValue{b: !n.args[0].eval(fields).b}
+	case "&&":
+		return exprValue{b: n.args[0].eval(fields).b && n.args[1].eval(fields).b}
+	case "||":
+		return exprValue{b: n.args[0].eval(fields).b || n.args[1].eval(fields).b}
+	case "=~", "!~":
+		v := n.args[0].eval(fields)
+		if v.missing {
+			return exprValue{}
+		}
+		return exprValue{b: n.re.MatchString(v.s) == (n.op == "=~")}
+	case "==", "!=", "<", "<=", ">", ">=":
+		return exprValue{b: n.compare(n.args[0].eval(fields), n.args[1].eval(fields))}
+	case "call":
+		return n.call(fields)
+	}
+	return exprValue{}
+}
+
+func parseNumber(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// a missing field, or a number compared with not a number, is false
+func (n *exprNode) compare(a, b exprValue) bool {
+	if a.missing || b.missing {
+		return false
+	}
+	var c int
+	left, right := n.args[0], n.args[1]
+	switch {
+	case left.typ == typeBool:
+		return (a.b == b.b) == (n.op == "==")
+	case a.isNum && b.isNum:
+		if a.n < b.n {
+			c = -1
+		} else if a.n > b.n {
+			c = 1
+		} else {
+			c = 0
+		}
+	case left.typ == typeNumber || right.typ == typeNumber:
+		return false
+	default:
+		c = strings.Compare(a.s, b.s)
+	}
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func (n *exprNode) call(fields map[string]string) exprValue {
+	if n.name == "exists" {
+		_, found := fields[n.args[0].name]
+		return exprValue{b: found}
+	}
+	a := n.args[0].eval(fields)
+	if a.missing {
+		return exprValue{missing: n.typ != typeBool}
+	}
+	switch n.name {
+	case "cidr":
+		ip := net.ParseIP(a.s)
+		return exprValue{b: ip != nil && n.ipnet.Contains(ip)}
+	case "contains":
+		b := n.args[1].eval(fields)
+		return exprValue{b: !b.missing && strings.Contains(a.s, b.s)}
+	case "lower":
+		return exprValue{s: strings.ToLower(a.s)}
+	}
+	return exprValue{}
+}
+
+// field source of an expression, named in "fields" directive
+type exprSource struct {
+	parse  FieldParser
+	schema map[string]exprType
+	closed bool // schema has all fields the parser returns
+}
+
+// fields of a syslog line parsed by ParseSyslog, or false if it has no header
+func parseSyslogFields(line string) (map[string]string, bool) {
+	if !hasSyslogHeader(line) {
+		return nil, false
+	}
+	m := ParseSyslog([]byte(line))
+	return map[string]string{
+		"facility": strconv.Itoa(m.Facility),
+		"severity": strconv.Itoa(m.Severity),
+		"host":     m.Hostname,
+		"app":      m.AppName,
+		"procid":   m.ProcID,
+		"msgid":    m.MsgID,
+		"msg":      m.Message,
+	}, true
+}
+
+// returns FieldParser of named captures of expr, and the names
+func regexpFields(expr string) (FieldParser, []string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := re.SubexpNames()
+	return func(line string) (map[string]string, bool) {
+		loc := re.FindStringSubmatchIndex(line)
+		if loc == nil {
+			return nil, false
+		}
+		fields := make(map[string]string)
+		for i, name := range names {
+			if len(name) > 0 && loc[2*i] >= 0 {
+				fields[name] = line[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		return fields, true
+	}, names, nil
+}
+
+// parses "fields <source> [arg]" directive
+func newExprSource(arg string) (*exprSource, error) {
+	name, rest := nextField(strings.TrimSpace(arg))
+	src := &exprSource{schema: map[string]exprType{"line": typeString}}
+	switch name {
+	case "syslog":
+		src.parse = parseSyslogFields
+		for _, name := range []string{"host", "app", "procid", "msgid", "msg"} {
+			src.schema[name] = typeAny
+		}
+		src.schema["severity"] = typeSeverity
+		src.schema["facility"] = typeNumber
+		src.closed = true
+	case "regexp":
+		parse, names, err := regexpFields(rest)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if len(name) > 0 {
+				src.schema[name] = typeAny
+			}
+		}
+		src.parse, src.closed = parse, true
+	default:
+		parse, found := fieldParsers[name]
+		if !found {
+			return nil, fmt.Errorf("unknown fields: %s", name)
+		}
+		src.parse = parse
+	}
+	return src, nil
+}
+
+// a compiled expression file
+type exprProgram struct {
+	root     *exprNode
+	source   *exprSource // nil for the line only
+	fallback bool
+}
+
+func (prog *exprProgram) filter(line string) bool {
+	fields := map[string]string{}
+	if prog.source != nil {
+		var ok bool
+		if fields, ok = prog.source.parse(line); !ok {
+			return prog.fallback
+		}
+	}
+	fields["line"] = line
+	return prog.root.eval(fields).b
+}
+
+// compiles an expression file, directives are blanked to keep positions
+func compileExpr(path string) (*exprProgram, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prog := &exprProgram{}
+	var src []string
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		head, arg := nextField(strings.TrimSpace(line))
+		switch head {
+		case "fields":
+			if prog.source, err = newExprSource(arg); err != nil {
+				return nil, &ExprError{Path: path, Line: lineno, Col: 1, Msg: err.Error()}
+			}
+			line = ""
+		case "fallback":
+			if arg != "pass" && arg != "drop" {
+				return nil, &ExprError{Path: path, Line: lineno, Col: 1, Msg: "fallback requires pass or drop"}
+			}
+			prog.fallback = arg == "pass"
+			line = ""
+		}
+		src = append(src, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{
+		path:   path,
+		src:    []rune(strings.Join(src, "\n")),
+		pos:    exprPos{1, 1},
+		schema: map[string]exprType{"line": typeString},
+	}
+	if prog.source != nil {
+		p.schema, p.closed = prog.source.schema, prog.source.closed
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if prog.root, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if p.tok.op != "" {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.tok.op)
+	}
+	if err := p.check(prog.root); err != nil {
+		return nil, err
+	}
+	if prog.root.typ != typeBool {
+		return nil, p.errorf(prog.root.pos, "expression must be bool, not %s", prog.root.typ)
+	}
+	return prog, nil
+}
+
+// exprFilter is expr: scheme, see ExprFilter.
+type exprFilter struct {
+	path string
+	prog atomic.Value // *exprProgram, swapped by Reload
+}
+
+// ExprFilter creates Filter from an expression file, registered as "expr:"
+// scheme. The file has an expression on fields and optional directives:
+//
+//	fields json|logfmt|syslog|regexp <regexp of named captures>
+//	fallback pass|drop
+//	severity <= "err" && host =~ "^db" && !cidr(client, "10.0.0.0/8")
+//
+// Operators are || && ! == != < <= > >= =~ !~ and functions are cidr(ip,
+// "net"), contains(s, sub), lower(s) and exists(field). Field "line" is the
+// whole line. Values are compared as numbers if both are. syslog severity is
+// compared with names like "err" as numbers, smaller is more severe. A
+// comparison on a missing field is false, and a line which fields do not parse
+// is dropped unless fallback is pass, e.g. a syslog line with neither PRI nor
+// TIMESTAMP. A field not in the regexp or syslog fields is an error. Errors
+// are ExprError with the position.
+func ExprFilter(path string) (Filter, error) {
+	prog, err := compileExpr(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &exprFilter{path: path}
+	f.prog.Store(prog)
+	return f, nil
+}
+
+func init() {
+	RegisterFilter("expr", ExprFilter)
+}
+
+func (f *exprFilter) Filter(line string) bool {
+	return f.prog.Load().(*exprProgram).filter(line)
+}
+
+// Reload compiles the file again, and keeps the current one on error.
+func (f *exprFilter) Reload() error {
+	prog, err := compileExpr(f.path)
+	if err != nil {
+		return err
+	}
+	f.prog.Store(prog)
+	return nil
+}
+
+func (f *exprFilter) Sources() []string {
+	return []string{f.path}
+}
+
+func (f *exprFilter) String() string {
+	return "expr:" + f.path
+}
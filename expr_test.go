@@ -0,0 +1,118 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExprFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "expr")
+
+	syslogs := []string{
+		"<11>Jan  2 15:04:05 db1 postgres[1]: connection from 10.1.2.3",
+		"<11>Jan  2 15:04:05 db2 postgres[1]: connection from 192.168.1.1",
+		"<14>Jan  2 15:04:05 db3 postgres[1]: connection from 192.168.1.2",
+		"<10>Jan  2 15:04:05 web1 nginx[2]: upstream down",
+	}
+	regexps := []string{
+		"GET /index 200 12",
+		"POST /login 500 1500",
+		"GET /health 200 1",
+		"garbage",
+	}
+	for _, c := range []struct {
+		expr   string
+		lines  []string
+		expect string
+	}{
+		{"fields regexp ^\\S+ +\\d+ \\S+ (?P<host>\\S+) .* from (?P<client>\\S+)$\n" + `host =~ "^db" && !cidr(client, "10.0.0.0/8")`, syslogs, "1,2"},
+		{"fields syslog\n" + `severity <= "err" && host =~ "^db"`, syslogs, "0,1"},
+		{"fields syslog\n" + `severity < "err" && app == "nginx" || contains(lower(msg), "FROM 192.168.1.2")`, syslogs, "3"},
+		{"fields regexp ^(?P<method>\\S+) (?P<path>\\S+) (?P<status>\\d+) (?P<ms>\\d+)\n# slow or failed\nstatus >= 500 || ms > 10", regexps, "0,1"},
+		{"fields regexp ^(?P<method>\\S+) (?P<path>\\S+)\nfallback pass\npath !~ \"health\"", regexps, "0,1,3"},
+		{`line =~ "^GET" && !(line =~ "health")`, regexps, "0"},
+		{"fields syslog\nfallback pass\n" + `severity <= "err"`, []string{syslogs[2], "no header", syslogs[0]}, "1,2"},
+		{"fields json\n" + `user.id == 42 && exists(level) == true`, []string{`{"user":{"id":"42"},"level":"x"}`, `{"user":{"id":42}}`}, "0"},
+	} {
+		if err := ioutil.WriteFile(fname, []byte(c.expr), 0666); err != nil {
+			t.Fatalf("failed to write expr: %s", err)
+		}
+		f, err := NewFilter("expr:" + fname)
+		if err != nil {
+			t.Fatalf("NewFilter(%q): %s", c.expr, err)
+		}
+		var passed []string
+		for i, line := range c.lines {
+			if f.Filter(line) {
+				passed = append(passed, string('0'+rune(i)))
+			}
+		}
+		if s := strings.Join(passed, ","); s != c.expect {
+			t.Fatalf("expr: %q, expect: %s, but got: %s", c.expr, c.expect, s)
+		}
+	}
+}
+
+func TestExprError(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "expr")
+
+	for _, c := range []struct {
+		expr   string
+		expect string // line:col: message
+	}{
+		{`a == "x" &&`, "1:12: unexpected end"},
+		{"fields syslog\n\n  severity <= \"bad\"", "3:15: unknown severity: bad"},
+		{`a =~ "("`, "1:6: error parsing regexp"},
+		{`a == 1 && b`, "1:11: && requires bool, not field"},
+		{`"x" == 1`, "1:5: mismatched types string and number"},
+		{`cidr(a, "10.0.0.0/33")`, "1:9: invalid CIDR"},
+		{`nofunc(a)`, "1:1: unknown function: nofunc"},
+		{`a == "unterminated`, "1:6: unterminated string"},
+		{`a`, "1:1: expression must be bool"},
+		{`(a == 1`, "1:8: expected )"},
+		{`a == 1 b`, "1:8: unexpected field"},
+		{`a @ 1`, "1:3: unexpected character"},
+		{"fields nothing\na == 1", "1:1: unknown fields: nothing"},
+		{"fields syslog\nhost == \"a\" && hots == \"b\"", "2:16: unknown field: hots"},
+		{"fields regexp ^(?P<method>\\S+)\nexists(methd)", "2:8: unknown field: methd"},
+	} {
+		if err := ioutil.WriteFile(fname, []byte(c.expr), 0666); err != nil {
+			t.Fatalf("failed to write expr: %s", err)
+		}
+		_, err := ExprFilter(fname)
+		if _, ok := err.(*ExprError); !ok || !strings.Contains(err.Error(), fname+":"+c.expect) {
+			t.Fatalf("expr: %q, expect error: %s, but got: %v", c.expr, c.expect, err)
+		}
+	}
+
+	// Reload reports the error and keeps the current one
+	if err := ioutil.WriteFile(fname, []byte(`line == "a"`), 0666); err != nil {
+		t.Fatalf("failed to write expr: %s", err)
+	}
+	f, err := ExprFilter(fname)
+	if err != nil {
+		t.Fatalf("ExprFilter: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte(`line ==`), 0666); err != nil {
+		t.Fatalf("failed to write expr: %s", err)
+	}
+	if err := f.Reload(); err == nil || !strings.Contains(err.Error(), ":1:8:") {
+		t.Fatalf("expect error with position, but got: %v", err)
+	}
+	if !f.Filter("a") {
+		t.Fatalf("expect the current expression kept")
+	}
+}
@@ -0,0 +1,322 @@
+package lotf
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldParser extracts fields of a line, nested ones are flattened to dotted
+// names like "user.id" with values in text. ok is false if the line is not in
+// the format.
+type FieldParser func(line string) (fields map[string]string, ok bool)
+
+var fieldParsers = map[string]FieldParser{
+	"json":   ParseJSONFields,
+	"logfmt": ParseLogfmtFields,
+}
+
+func init() {
+	for name, parser := range fieldParsers {
+		name, parser := name, parser
+		RegisterFilter(name, func(arg string) (Filter, error) {
+			return FieldFilter(parser, name, arg)
+		})
+	}
+}
+
+// flattens v decoded by json with UseNumber into fields
+func flatten(prefix string, v interface{}, fields map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if len(prefix) > 0 {
+				k = prefix + "." + k
+			}
+			flatten(k, e, fields)
+		}
+	case []interface{}:
+		for i, e := range t {
+			flatten(fmt.Sprintf("%s.%d", prefix, i), e, fields)
+		}
+	case nil:
+		fields[prefix] = ""
+	default:
+		fields[prefix] = fmt.Sprint(t)
+	}
+}
+
+// ParseJSONFields parses a line of JSON object.
+func ParseJSONFields(line string) (map[string]string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	flatten("", v, fields)
+	return fields, true
+}
+
+// ParseLogfmtFields parses a line of key=value pairs separated by spaces, value
+// can be quoted with escapes. A key with no value is "true".
+func ParseLogfmtFields(line string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	pairs := 0
+	for i := 0; i < len(line); {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if len(key) == 0 {
+			if i < len(line) && line[i] == '=' {
+				return nil, false
+			}
+			continue
+		}
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = "true"
+			continue
+		}
+		i++
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = value
+			i = end + 1
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			fields[key] = line[start:i]
+		}
+		pairs++
+	}
+	return fields, pairs > 0
+}
+
+// a condition on a field
+type fieldCond struct {
+	field  string
+	op     string
+	values []fieldValue   // one except in
+	re     *regexp.Regexp // for =~
+}
+
+// a value of condition
+type fieldValue struct {
+	s      string
+	quoted bool // compared as a string even if it is a number
+}
+
+var fieldOps = []string{"==", "!=", "<=", ">=", "=~", "<", ">", "in"}
+
+// compares a and b as numbers if both are, otherwise as strings
+func compareValues(a, b string) int {
+	x, errx := strconv.ParseFloat(a, 64)
+	y, erry := strconv.ParseFloat(b, 64)
+	if errx != nil || erry != nil {
+		return strings.Compare(a, b)
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compares field value v with fv
+func (fv fieldValue) compare(v string) int {
+	if fv.quoted {
+		return strings.Compare(v, fv.s)
+	}
+	return compareValues(v, fv.s)
+}
+
+func (c *fieldCond) eval(fields map[string]string) bool {
+	v, found := fields[c.field]
+	if !found {
+		return false
+	}
+	switch c.op {
+	case "==":
+		return c.values[0].compare(v) == 0
+	case "!=":
+		return c.values[0].compare(v) != 0
+	case "<":
+		return c.values[0].compare(v) < 0
+	case "<=":
+		return c.values[0].compare(v) <= 0
+	case ">":
+		return c.values[0].compare(v) > 0
+	case ">=":
+		return c.values[0].compare(v) >= 0
+	case "=~":
+		return c.re.MatchString(v)
+	case "in":
+		for _, fv := range c.values {
+			if fv.compare(v) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns the value unquoted if it is quoted
+func condValue(s string) (fieldValue, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		v, err := strconv.Unquote(s)
+		return fieldValue{s: v, quoted: true}, err
+	}
+	if len(s) == 0 {
+		return fieldValue{}, fmt.Errorf("no value")
+	}
+	return fieldValue{s: s}, nil
+}
+
+// splits s by ',' out of double quotes
+func splitValues(s string) []string {
+	var values []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ',':
+			values = append(values, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, s[start:])
+}
+
+// parses "field op value", value of in is "(a,b,...)"
+func parseFieldCond(s string) (*fieldCond, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " =!<>")
+	if i <= 0 {
+		return nil, fmt.Errorf("no field in condition: %s", s)
+	}
+	c := &fieldCond{field: s[:i]}
+	rest := strings.TrimLeft(s[i:], " ")
+	for _, op := range fieldOps {
+		if strings.HasPrefix(rest, op) {
+			c.op = op
+			rest = rest[len(op):]
+			break
+		}
+	}
+	if len(c.op) == 0 {
+		return nil, fmt.Errorf("no operator in condition: %s", s)
+	}
+
+	if c.op == "in" {
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+			return nil, fmt.Errorf("in requires (value,...): %s", s)
+		}
+		for _, e := range splitValues(rest[1 : len(rest)-1]) {
+			v, err := condValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", err, s)
+			}
+			c.values = append(c.values, v)
+		}
+		return c, nil
+	}
+	v, err := condValue(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, s)
+	}
+	c.values = []fieldValue{v}
+	if c.op == "=~" {
+		if c.re, err = regexp.Compile(v.s); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// fieldFilter is Filter of json: and logfmt: schemes, see FieldFilter.
+type fieldFilter struct {
+	parse    FieldParser
+	spec     string
+	cond     *fieldCond
+	fallback bool // result for a line not parsed
+}
+
+// FieldFilter creates Filter which parses lines by parse and evaluates spec,
+// "<field> <op> <value>[; fallback=pass|drop]". op is one of == != < <= > >=,
+// =~ for regexp or in for a list like "level in (error,fatal)". Values are
+// compared as numbers if both are, and can be quoted to be compared as strings,
+// e.g. "010" does not equal 10. A quoted value in a list can contain ','. A
+// line which is not parsed or has no field is dropped unless fallback is pass.
+// This is registered as "json:" and "logfmt:" scheme.
+func FieldFilter(parse FieldParser, format, spec string) (Filter, error) {
+	f := &fieldFilter{parse: parse, spec: format + ":" + spec}
+	if i := strings.LastIndex(spec, ";"); i >= 0 {
+		if opt := strings.TrimSpace(spec[i+1:]); strings.HasPrefix(opt, "fallback=") {
+			switch opt {
+			case "fallback=pass":
+				f.fallback = true
+			case "fallback=drop":
+			default:
+				return nil, fmt.Errorf("unknown fallback: %s", opt)
+			}
+			spec = spec[:i]
+		}
+	}
+	var err error
+	if f.cond, err = parseFieldCond(spec); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fieldFilter) Filter(line string) bool {
+	fields, ok := f.parse(line)
+	if !ok {
+		return f.fallback
+	}
+	if _, found := fields[f.cond.field]; !found {
+		return f.fallback
+	}
+	return f.cond.eval(fields)
+}
+
+func (f *fieldFilter) Reload() error {
+	return nil
+}
+
+func (f *fieldFilter) String() string {
+	return f.spec
+}
@@ -0,0 +1,72 @@
+package lotf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogfmtFields(t *testing.T) {
+	fields, ok := ParseLogfmtFields(`level=info msg="hello \"world\"" dur=12ms  flag`)
+	if !ok {
+		t.Fatalf("failed to parse logfmt")
+	}
+	for k, v := range map[string]string{"level": "info", "msg": `hello "world"`, "dur": "12ms", "flag": "true"} {
+		if fields[k] != v {
+			t.Fatalf("field %s expect: %q, but got: %q", k, v, fields[k])
+		}
+	}
+	for _, line := range []string{"", "just words", `msg="unterminated`, "=x"} {
+		if _, ok := ParseLogfmtFields(line); ok {
+			t.Fatalf("expect not parsed: %q", line)
+		}
+	}
+}
+
+func TestFieldFilter(t *testing.T) {
+	lines := []string{
+		`{"level":"error","status":503,"duration_ms":1500,"user":{"id":"42"}}`,
+		`{"level":"info","status":200,"duration_ms":20,"user":{"id":42}}`,
+		`{"level":"fatal","status":500,"tags":["db","x"]}`,
+		`level=warn status=404 duration_ms=1001 msg="not found"`,
+		`plain text line`,
+	}
+	for _, c := range []struct {
+		spec   string
+		expect string
+	}{
+		{"json:level in (error,fatal)", "0,2"},
+		{"json:status >= 500", "0,2"},
+		{"json:duration_ms > 1000", "0"},
+		{`json:user.id == "42"`, "0,1"},
+		{"json:tags.0 == db", "2"},
+		{"json:level != info; fallback=pass", "0,2,3,4"},
+		{"json:level =~ ^(e|f)", "0,2"},
+		{"logfmt:status>=400", "3"},
+		{`logfmt:msg == "not found"`, "3"},
+		{"json:status >= 500 || logfmt:duration_ms > 1000", "0,2,3"},
+		{"json:status == 503.0", "0"},
+		{`json:status == "503.0"`, ""},
+		{`json:status in ("0200", 500)`, "2"},
+		{`logfmt:msg in ("a,b", "not found")`, "3"},
+	} {
+		f, err := NewFilter(c.spec)
+		if err != nil {
+			t.Fatalf("NewFilter(%s): %s", c.spec, err)
+		}
+		var passed []string
+		for i, line := range lines {
+			if f.Filter(line) {
+				passed = append(passed, string('0'+rune(i)))
+			}
+		}
+		if s := strings.Join(passed, ","); s != c.expect {
+			t.Fatalf("spec: %s, expect: %s, but got: %s", c.spec, c.expect, s)
+		}
+	}
+
+	for _, spec := range []string{"json:level", "json:== 1", "json:level in error", "json:a =~ (", "json:a == 1; fallback=maybe", "json:a == "} {
+		if _, err := NewFilter(spec); err == nil {
+			t.Fatalf("expect error from spec: %s", spec)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package lotf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FilterFactory creates Filter from the part of a spec after "scheme:".
+type FilterFactory func(arg string) (Filter, error)
+
+var filterSchemes = struct {
+	sync.RWMutex
+	m map[string]FilterFactory
+}{m: make(map[string]FilterFactory)}
+
+func init() {
+	RegisterFilter("file", RegexpFilter)
+	RegisterFilter("regexp", newMatchFilter)
+	RegisterFilter("substr", newSubstrFilter)
+}
+
+// RegisterFilter makes specs "scheme:arg" resolved by factory in NewFilter.
+// Registering the same scheme again replaces the factory.
+func RegisterFilter(scheme string, factory FilterFactory) {
+	filterSchemes.Lock()
+	defer filterSchemes.Unlock()
+	filterSchemes.m[scheme] = factory
+}
+
+// NewFilter creates Filter from spec, which is terms joined by " && " or
+// " || ", && binds tighter. A term is "scheme:arg" of a registered scheme, or a
+// filter file name for RegexpFilter if it has no known scheme. A leading "!"
+// inverts the term. arg or the file name can be double quoted as Go string
+// literal to contain " && " or " || ", e.g. regexp:"a || b". Built-in schemes
+// are:
+//
+//	file:<filter file>  lines matching one of the regexps in the file
+//	regexp:<regexp>     lines matching the regexp
+//	substr:<string>     lines containing the string
+func NewFilter(spec string) (Filter, error) {
+	terms, ops, err := splitSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	var ors, ands []Filter
+	for i, term := range terms {
+		f, err := newTerm(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		ands = append(ands, f)
+		if i == len(ops) || ops[i] == " || " {
+			ors = append(ors, And(ands...))
+			ands = nil
+		}
+	}
+	return Or(ors...), nil
+}
+
+// splits spec into terms and " && " or " || " between them
+func splitSpec(spec string) ([]string, []string, error) {
+	var terms, ops []string
+	for {
+		n, err := termEnd(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		terms = append(terms, spec[:n])
+		if n == len(spec) {
+			return terms, ops, nil
+		}
+		ops = append(ops, spec[n:n+4])
+		spec = spec[n+4:]
+	}
+}
+
+// returns the length of the term at the head of s, which ends at " && " or
+// " || " after the arg if it is double quoted
+func termEnd(s string) (int, error) {
+	i := len(s) - len(strings.TrimLeft(s, " !"))
+	if k := strings.IndexByte(s[i:], ':'); k > 0 && strings.HasPrefix(s[i+k+1:], `"`) {
+		filterSchemes.RLock()
+		_, found := filterSchemes.m[s[i:i+k]]
+		filterSchemes.RUnlock()
+		if found {
+			i += k + 1
+		}
+	}
+	if strings.HasPrefix(s[i:], `"`) {
+		for i++; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated quote in filter spec: %s", s)
+		}
+	} else {
+		i = 0
+	}
+	end := len(s)
+	for _, op := range []string{" && ", " || "} {
+		if j := strings.Index(s[i:], op); j >= 0 && i+j < end {
+			end = i + j
+		}
+	}
+	return end, nil
+}
+
+// returns s unquoted if it is double quoted
+func unquoteArg(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	return strconv.Unquote(s)
+}
+
+func newTerm(term string) (Filter, error) {
+	if len(term) == 0 {
+		return nil, fmt.Errorf("empty filter spec")
+	}
+	if strings.HasPrefix(term, "!") {
+		f, err := newTerm(term[1:])
+		if err != nil {
+			return nil, err
+		}
+		return Not(f), nil
+	}
+	if i := strings.IndexByte(term, ':'); i > 0 {
+		filterSchemes.RLock()
+		factory, found := filterSchemes.m[term[:i]]
+		filterSchemes.RUnlock()
+		if found {
+			arg, err := unquoteArg(term[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted filter arg: %s", term)
+			}
+			f, err := factory(arg)
+			if err != nil {
+				return nil, err
+			}
+			return withStats(f), nil
+		}
+	}
+	name, err := unquoteArg(term)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quoted filter file: %s", term)
+	}
+	return RegexpFilter(name)
+}
+
+// returns f.String() or its type
+func filterString(f Filter) string {
+	if s, ok := f.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+// reloads all filters and returns the first error
+func reloadAll(filters []Filter) error {
+	var first error
+	for _, f := range filters {
+		if err := f.Reload(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func joinFilters(filters []Filter, sep string) string {
+	s := make([]string, len(filters))
+	for i, f := range filters {
+		s[i] = filterString(f)
+	}
+	return strings.Join(s, sep)
+}
+
+type andFilter struct {
+	filters []Filter
+	counter counter
+}
+
+// And returns Filter which passes a line all of filters pass. Reload reloads
+// all of them.
+func And(filters ...Filter) Filter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return &andFilter{filters: filters}
+}
+
+func (f *andFilter) Filter(line string) bool {
+	return f.filterBy(line, STATS_TOP)
+}
+
+func (f *andFilter) filterBy(line string, mode statsMode) bool {
+	pass := true
+	for _, filter := range f.filters {
+		if !evalFilter(filter, line, mode.dropping()) {
+			pass = false
+			break
+		}
+	}
+	return f.counter.countTop(mode, pass)
+}
+
+// Match returns the matches of all Matchers in filters, rules joined by " && ".
+func (f *andFilter) Match(line string) (bool, *Match) {
+	return f.matchBy(line, STATS_TOP)
+}
+
+func (f *andFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	var match *Match
+	for _, filter := range f.filters {
+		pass, m := evalMatch(filter, line, mode.dropping())
+		if !pass {
+			f.counter.countTop(mode, false)
+			return false, nil
+		}
+		if m == nil {
+			continue
+		}
+		if match == nil {
+			match = &Match{Rule: m.Rule, Spans: m.Spans}
+		} else {
+			match.Rule += " && " + m.Rule
+			match.Spans = mergeSpans(match.Spans, m.Spans)
+		}
+	}
+	f.counter.countTop(mode, true)
+	return true, match
+}
+
+func (f *andFilter) Reload() error {
+	return reloadAll(f.filters)
+}
+
+func (f *andFilter) Sources() []string {
+	var files []string
+	for _, filter := range f.filters {
+		files = appendSources(files, filter)
+	}
+	return files
+}
+
+// Stats returns the rules of all filters.
+func (f *andFilter) Stats() *FilterStats {
+	return joinStats(f, &f.counter, f.filters)
+}
+
+func (f *andFilter) String() string {
+	return joinFilters(f.filters, " && ")
+}
+
+type orFilter struct {
+	filters []Filter
+	counter counter
+}
+
+// Or returns Filter which passes a line one of filters passes. Reload reloads
+// all of them.
+func Or(filters ...Filter) Filter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return &orFilter{filters: filters}
+}
+
+func (f *orFilter) Filter(line string) bool {
+	return f.filterBy(line, STATS_TOP)
+}
+
+func (f *orFilter) filterBy(line string, mode statsMode) bool {
+	pass := false
+	for _, filter := range f.filters {
+		if evalFilter(filter, line, mode.nested()) {
+			pass = true
+			break
+		}
+	}
+	return f.counter.countTop(mode, pass)
+}
+
+// Match returns the match of the first filter which passes line.
+func (f *orFilter) Match(line string) (bool, *Match) {
+	return f.matchBy(line, STATS_TOP)
+}
+
+func (f *orFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	for _, filter := range f.filters {
+		if pass, m := evalMatch(filter, line, mode.nested()); pass {
+			return f.counter.countTop(mode, true), m
+		}
+	}
+	return f.counter.countTop(mode, false), nil
+}
+
+func (f *orFilter) Reload() error {
+	return reloadAll(f.filters)
+}
+
+func (f *orFilter) Sources() []string {
+	var files []string
+	for _, filter := range f.filters {
+		files = appendSources(files, filter)
+	}
+	return files
+}
+
+// Stats returns the rules of all filters.
+func (f *orFilter) Stats() *FilterStats {
+	return joinStats(f, &f.counter, f.filters)
+}
+
+func (f *orFilter) String() string {
+	return joinFilters(f.filters, " || ")
+}
+
+type notFilter struct {
+	filter  Filter
+	counter counter
+}
+
+// Not returns Filter which passes a line filter drops.
+func Not(filter Filter) Filter {
+	return &notFilter{filter: filter}
+}
+
+func (f *notFilter) Filter(line string) bool {
+	return f.filterBy(line, STATS_TOP)
+}
+
+func (f *notFilter) filterBy(line string, mode statsMode) bool {
+	pass := !evalFilter(f.filter, line, mode.nested())
+	return f.counter.countTop(mode, pass)
+}
+
+// not Matcher, the match of filter is not of the line
+func (f *notFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	return f.filterBy(line, mode), nil
+}
+
+func (f *notFilter) Reload() error {
+	return f.filter.Reload()
+}
+
+func (f *notFilter) Sources() []string {
+	return appendSources(nil, f.filter)
+}
+
+// Stats returns the rules of filter.
+func (f *notFilter) Stats() *FilterStats {
+	return joinStats(f, &f.counter, []Filter{f.filter})
+}
+
+func (f *notFilter) String() string {
+	return "!" + filterString(f.filter)
+}
+
+// matchFilter is regexp: scheme, which has nothing to reload
+type matchFilter struct {
+	re *literalRegexp
+}
+
+func newMatchFilter(arg string) (Filter, error) {
+	re, err := compileLiteralRegexp(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &matchFilter{re}, nil
+}
+
+func (f *matchFilter) Filter(line string) bool {
+	return f.re.MatchString(line)
+}
+
+func (f *matchFilter) Match(line string) (bool, *Match) {
+	if !f.re.MatchString(line) {
+		return false, nil
+	}
+	return true, &Match{Rule: f.re.String(), Spans: regexpSpans(f.re.re, line)}
+}
+
+func (f *matchFilter) Reload() error {
+	return nil
+}
+
+func (f *matchFilter) String() string {
+	return "regexp:" + f.re.String()
+}
+
+// substrFilter is substr: scheme
+type substrFilter string
+
+func newSubstrFilter(arg string) (Filter, error) {
+	if len(arg) == 0 {
+		return nil, fmt.Errorf("empty substr filter")
+	}
+	return substrFilter(arg), nil
+}
+
+func (f substrFilter) Filter(line string) bool {
+	return strings.Contains(line, string(f))
+}
+
+func (f substrFilter) Match(line string) (bool, *Match) {
+	spans := substrSpans(line, string(f), false)
+	if len(spans) == 0 {
+		return false, nil
+	}
+	return true, &Match{Rule: string(f), Spans: spans}
+}
+
+func (f substrFilter) Reload() error {
+	return nil
+}
+
+func (f substrFilter) String() string {
+	return "substr:" + string(f)
+}
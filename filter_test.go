@@ -0,0 +1,105 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type upperFilter struct{}
+
+func (f upperFilter) Filter(line string) bool { return strings.ToUpper(line) == line }
+func (f upperFilter) Reload() error           { return nil }
+
+func TestNewFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filterFile := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(filterFile, []byte("^GET\n^POST\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	RegisterFilter("upper", func(string) (Filter, error) { return upperFilter{}, nil })
+
+	lines := []string{"GET /health", "GET /index", "POST /login", "DELETE /x", "get /x"}
+	for _, c := range []struct {
+		spec   string
+		expect string
+	}{
+		{filterFile, "GET /health,GET /index,POST /login"},
+		{"!" + filterFile, "DELETE /x,get /x"},
+		{"perexp:" + filterFile, ""},
+		{"perexp:!" + filterFile, "DELETE /x,get /x"},
+		{"perexp:" + filterFile + " || substr:/x", "DELETE /x,get /x"},
+		{"file:" + filterFile + " && !substr:health", "GET /index,POST /login"},
+		{"regexp:^DEL || substr:login", "POST /login,DELETE /x"},
+		{"!upper: && substr:x || substr:health", "GET /health,GET /index,DELETE /x,get /x"},
+		{`regexp:"^GET" && substr:"h"`, "GET /health"},
+		{`substr:"x && y" || substr:"\"" || regexp:"LETE"`, "DELETE /x"},
+		{`"` + filterFile + `"`, "GET /health,GET /index,POST /login"},
+		{`substr:/x && !substr:"a || b" || substr:"health"`, "GET /health,DELETE /x,get /x"},
+		{`regexp:[^"]ET`, "GET /health,GET /index,DELETE /x"},
+	} {
+		f, err := NewFilter(c.spec)
+		if err != nil {
+			t.Fatalf("NewFilter(%s): %s", c.spec, err)
+		}
+		var passed []string
+		for _, line := range lines {
+			if f.Filter(line) {
+				passed = append(passed, line)
+			}
+		}
+		if s := strings.Join(passed, ","); s != c.expect {
+			t.Fatalf("spec: %s, expect: %s, but got: %s", c.spec, c.expect, s)
+		}
+	}
+
+	for _, spec := range []string{"", "regexp:(", "substr:", "x && ", `substr:"x`, `substr:"x"y`, `substr:a && regexp:"b`} {
+		if _, err := NewFilter(spec); err == nil {
+			t.Fatalf("expect error from spec: %q", spec)
+		}
+	}
+
+	// reload propagates to the file filter in composite
+	f, err := NewFilter("substr:/ && !" + filterFile)
+	if err != nil {
+		t.Fatalf("NewFilter: %s", err)
+	}
+	perexp, err := NewFilter("perexp:" + filterFile)
+	if err != nil {
+		t.Fatalf("NewFilter: %s", err)
+	}
+	if s := filterString(perexp); s != "perexp:"+filterFile {
+		t.Fatalf("expect perexp:%s, but got: %s", filterFile, s)
+	}
+	if f.Filter("GET /index") {
+		t.Fatalf("expect GET dropped before reload")
+	}
+	if err := ioutil.WriteFile(filterFile, []byte("^POST\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if !f.Filter("GET /index") || f.Filter("POST /login") {
+		t.Fatalf("expect reloaded filter")
+	}
+	// reloaded by the same strategy, all of one regexp
+	if err := perexp.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if !perexp.Filter("POST /login") {
+		t.Fatalf("expect reloaded perexp filter")
+	}
+	if err := ioutil.WriteFile(filterFile, []byte("(\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	if err := f.Reload(); err == nil {
+		t.Fatalf("expect error reloading invalid filter")
+	}
+}
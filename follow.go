@@ -0,0 +1,63 @@
+package lotf
+
+import (
+	inotify "github.com/chamaken/inotify"
+	"github.com/golang/glog"
+	"syscall"
+)
+
+// event handler of FOLLOW_DESCRIPTOR tail, which watches the file itself.
+// Events of the name from a directory watch are ignored except IN_MODIFY,
+// which only makes it check the size. Unlinking the file sends IN_ATTRIB, then
+// IN_DELETE_SELF comes after the descriptor is closed, which ends the tail.
+func (tw *TailWatcher) handleDescriptor(tail *TailName, mask uint32) {
+	switch {
+	case mask&inotify.IN_MODIFY != 0:
+		tail.handleModify(tw.errch)
+	case mask&inotify.IN_ATTRIB != 0:
+		if tail.file == nil {
+			return
+		}
+		fi, err := tail.file.Stat()
+		if err != nil {
+			glog.Infof("File.Stat(): %s", err)
+			tail.sendError(tw.errch, "stat", SEVERITY_ERROR, err)
+			return
+		}
+		if fi.Sys().(*syscall.Stat_t).Nlink == 0 {
+			tail.handleDisappear(tw.errch)
+		}
+	case mask&inotify.IN_DELETE_SELF != 0:
+		tw.mu.Lock()
+		if tw.tails[tail.name] == tail {
+			delete(tw.tails, tail.name)
+		}
+		tw.mu.Unlock()
+		// kernel has removed the watch already
+		if err := tw.watch.RemoveWatch(tail.name); err != nil && glog.V(1) {
+			glog.Infof("inotify.RemoveWatch(): %s", err)
+		}
+		tail.lines.Done()
+	}
+}
+
+// removes FOLLOW_DESCRIPTOR tail, caller must hold tw.mu
+func (tw *TailWatcher) removeDescriptor(tail *TailName) error {
+	if tail.file != nil {
+		if err := tail.file.Close(); err != nil {
+			if glog.V(1) {
+				glog.Infof("File.Close(): %s", err)
+			}
+			return err
+		}
+	}
+	tail.lines.Done()
+	delete(tw.tails, tail.name)
+	if err := tw.watch.RemoveWatch(tail.name); err != nil {
+		if glog.V(1) {
+			glog.Infof("inotify.RemoveWatch(): %s", err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFollowDescriptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	moved := fname + ".1"
+	if err := ioutil.WriteFile(fname, []byte("a\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.AddOptions(fname, 8, nil, 1, &TailOptions{Follow: FOLLOW_DESCRIPTOR})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	// watched by name in the same directory, whose events should be ignored
+	other := filepath.Join(dir, "TailWatcher.other")
+	if err := ioutil.WriteFile(other, nil, 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	if _, err := tw.Add(other, 8, nil, 0); err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	if line := tail.NextLine(); line == nil || line.Text != "a" {
+		t.Fatalf("expect a, but got: %v", line)
+	}
+
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	if err := os.Rename(fname, moved); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("new\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	wfile.WriteString("b\n")
+	if line := tail.WaitNextLine(); line == nil || line.Text != "b" {
+		t.Fatalf("expect b, but got: %v", line)
+	}
+
+	wfile.WriteString("c")
+	wfile.Close()
+	if err := os.Remove(moved); err != nil {
+		t.Fatalf("failed to remove: %s", err)
+	}
+	if line := tail.WaitNextLine(); line == nil || line.Text != "c" {
+		t.Fatalf("expect c, but got: %v", line)
+	}
+	if line := tail.WaitNextLine(); line != nil {
+		t.Fatalf("expect end of lines, but got: %s", line)
+	}
+	if _, err := tw.Lookup(fname); err == nil {
+		t.Fatalf("ended tail should be removed")
+	}
+}
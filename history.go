@@ -0,0 +1,175 @@
+package lotf
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"os"
+)
+
+// returns the name of i th rotated sibling, i == 0 means the name itself.
+func rotatedName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%d", name, i)
+}
+
+// History returns at most n lines, oldest first, which start before the
+// offset before. These are read from the file on disk, not from Blockq, so that
+// lines evicted from Blockq can be read by passing Offset of the oldest Line
+// read so far. Only lines filter accepts are returned if filter is not nil.
+//
+// If RotatedHistory was specified in TailOptions, this continues to rotated
+// siblings name.1, name.2... as if these were concatenated in front of the
+// current file, so that lines in them have negative offset. Offsets are
+// meaningless across a rotation of the current file.
+//
+// A stream of AddReader has no history and ErrorNoHistory is returned, while a
+// regular file of AddFile is read through its descriptor. Lines are rewritten
+// by Mapper as stored ones.
+func (tail *TailName) History(before int64, n int, filter Filter) ([]*Line, error) {
+	if tail.stream != nil {
+		return tail.fileHistory(before, n, filter)
+	}
+
+	lines := make([]*Line, 0) // newest first, reversed at last
+	end := before             // reads [base, end) of the virtual offset
+	base := int64(0)          // virtual offset of the file head
+
+	for i := 0; len(lines) < n; i++ {
+		if i > 0 && !tail.rotated {
+			break
+		}
+		name := rotatedName(tail.name, i)
+		file, err := os.Open(name)
+		if err != nil {
+			if i > 0 && os.IsNotExist(err) {
+				break
+			}
+			if glog.V(1) {
+				glog.Infof("Open(%s): %s", name, err)
+			}
+			return nil, err
+		}
+		fi, err := file.Stat()
+		if err != nil {
+			file.Close()
+			if glog.V(1) {
+				glog.Infof("File.Stat(): %s", err)
+			}
+			return nil, err
+		}
+		if i > 0 {
+			base -= fi.Size()
+		}
+		if end <= base { // before points older one
+			file.Close()
+			continue
+		}
+
+		size := end - base
+		if size > fi.Size() {
+			size = fi.Size()
+		}
+		// the last line of rotated one will never be followed by NL
+		complete := i > 0 && size == fi.Size()
+		lines, err = readHistory(io.NewSectionReader(file, 0, size), base, complete, n, filter, lines)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		end = base
+	}
+
+	reverse(lines)
+	tail.mapHistory(lines)
+	return lines, nil
+}
+
+func (tail *TailName) mapHistory(lines []*Line) {
+	if tail.mapper == nil {
+		return
+	}
+	for _, line := range lines {
+		line.Text, line.Match = mapLine(tail.mapper, line)
+	}
+}
+
+func reverse(lines []*Line) {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+}
+
+// History of the file added by AddFile
+func (tail *TailName) fileHistory(before int64, n int, filter Filter) ([]*Line, error) {
+	if tail.file == nil {
+		return nil, ErrorNoHistory
+	}
+	fi, err := tail.file.Stat()
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("File.Stat(): %s", err)
+		}
+		return nil, err
+	}
+	if before > fi.Size() {
+		before = fi.Size()
+	}
+	lines, err := readHistory(io.NewSectionReader(tail.file, 0, before), 0, false, n, filter, make([]*Line, 0))
+	if err != nil {
+		return nil, err
+	}
+	reverse(lines)
+	tail.mapHistory(lines)
+	return lines, nil
+}
+
+// reads lines backward from the end of r and appends them to lines until its
+// length reaches n. base is added to line offsets. The last fragment which is
+// not ended with NL is regarded as a line only if complete is true.
+func readHistory(r *io.SectionReader, base int64, complete bool, n int, filter Filter, lines []*Line) ([]*Line, error) {
+	tr, err := NewTailReader(r)
+	if err == ErrorEmpty {
+		return lines, nil
+	} else if err != nil {
+		return lines, err
+	}
+
+	first := true
+	for len(lines) < n {
+		line, err := tr.PrevBytes('\n')
+		if err != nil && err != ErrorStartOfFile {
+			if glog.V(1) {
+				glog.Infof("TailReader.PrevBytes(): %s", err)
+			}
+			return lines, err
+		}
+		sof := err == ErrorStartOfFile
+
+		offset := int64(0)
+		terminated := len(line) > 0 && line[0] == '\n'
+		if terminated {
+			line = line[1:]
+			offset = tr.Tell() + 1
+		}
+		if first {
+			first = false
+			// skip the last NL or an unfinished line
+			if (terminated && len(line) == 0) || !complete {
+				if sof {
+					break
+				}
+				continue
+			}
+		}
+		if pass, m := evalMatch(filter, string(line), STATS_NONE); pass {
+			lines = append(lines, &Line{Text: string(line), Offset: base + offset, Match: m})
+		}
+		if sof {
+			break
+		}
+	}
+	return lines, nil
+}
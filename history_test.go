@@ -0,0 +1,132 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func historyString(lines []*Line) string {
+	s := ""
+	for _, l := range lines {
+		s += fmt.Sprintf("%s@%d,", l.Text, l.Offset)
+	}
+	return s
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname+".1", []byte("r1\nr2\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	if err := ioutil.WriteFile(fname+".2", []byte("\nrr"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("a\nb\nc\nd\ne"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.AddOptions(fname, 2, nil, 2, &TailOptions{RotatedHistory: true})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	oldest := tail.NextLine()
+	if oldest.Text != "c" || oldest.Offset != 4 {
+		t.Fatalf("expect c@4, but got: %s@%d", oldest.Text, oldest.Offset)
+	}
+	if line := tail.NextLine(); line.Text != "d" || line.Offset != 6 {
+		t.Fatalf("expect d@6, but got: %s@%d", line.Text, line.Offset)
+	}
+
+	lines, err := tail.History(oldest.Offset, 10, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "@-9,rr@-8,r1@-6,r2@-3,a@0,b@2," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	lines, err = tail.History(oldest.Offset, 3, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "r2@-3,a@0,b@2," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	// continue from the oldest one
+	lines, err = tail.History(lines[0].Offset, 10, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "@-9,rr@-8,r1@-6," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	// in the middle of a line, and unfinished last line
+	lines, err = tail.History(3, 1, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "a@0," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+	lines, err = tail.History(100, 1, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "d@6," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	// with filter
+	filterFile := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(filterFile, []byte("^r\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	filter, err := RegexpFilter(filterFile)
+	if err != nil {
+		t.Fatalf("failed to create filter: %s", err)
+	}
+	lines, err = tail.History(oldest.Offset, 10, filter)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "rr@-8,r1@-6,r2@-3," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	// rotated siblings are not read without the option
+	if err = tw.Remove(fname); err != nil {
+		t.Fatalf("failed to Remove from TailWatcher: %s", err)
+	}
+	if tail, err = tw.Add(fname, 2, nil, 2); err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	lines, err = tail.History(oldest.Offset, 10, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "a@0,b@2," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+}
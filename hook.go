@@ -0,0 +1,125 @@
+package lotf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type TailEventType int
+
+const (
+	TAIL_CREATE   TailEventType = iota // file appeared at the watching name
+	TAIL_ROTATE                        // the name refers to a new file
+	TAIL_TRUNCATE                      // file size became smaller than read offset
+	TAIL_DELETE                        // file was removed or moved from the name
+	TAIL_ERROR                         // error occurred while handling the file
+)
+
+var tailEventNames = []string{
+	TAIL_CREATE:   "create",
+	TAIL_ROTATE:   "rotate",
+	TAIL_TRUNCATE: "truncate",
+	TAIL_DELETE:   "delete",
+	TAIL_ERROR:    "error",
+}
+
+func (t TailEventType) String() string {
+	if t < 0 || int(t) >= len(tailEventNames) {
+		return fmt.Sprintf("TailEventType(%d)", int(t))
+	}
+	return tailEventNames[t]
+}
+
+// TailEvent describes a lifecycle change of a watching file. Inode and offset
+// fields which are not meaningful for the type are 0.
+type TailEvent struct {
+	Type      TailEventType
+	Name      string // file absname
+	Time      time.Time
+	OldIno    uint64 // inode of the file followed before the event
+	NewIno    uint64 // inode of the file followed after the event
+	OldOffset int64  // read offset of the file before the event
+	NewOffset int64  // read offset of the file after the event
+	Err       error  // TAIL_ERROR only
+}
+
+func (ev *TailEvent) String() string {
+	switch ev.Type {
+	case TAIL_CREATE:
+		return fmt.Sprintf("%s: created, inode: %d", ev.Name, ev.NewIno)
+	case TAIL_ROTATE:
+		return fmt.Sprintf("%s: rotated, inode: %d -> %d", ev.Name, ev.OldIno, ev.NewIno)
+	case TAIL_TRUNCATE:
+		return fmt.Sprintf("%s: truncated, offset: %d -> %d", ev.Name, ev.OldOffset, ev.NewOffset)
+	case TAIL_DELETE:
+		return fmt.Sprintf("%s: removed, inode: %d", ev.Name, ev.OldIno)
+	case TAIL_ERROR:
+		return fmt.Sprintf("%s: %s", ev.Name, ev.Err)
+	}
+	return fmt.Sprintf("%s: %s", ev.Name, ev.Type)
+}
+
+// Hooks is a set of callbacks for TailWatcher.Subscribe. nil member is just
+// ignored. These are called from TailWatcher event dispatcher goroutine so
+// that it should not block.
+type Hooks struct {
+	OnCreate   func(*TailEvent)
+	OnRotate   func(*TailEvent)
+	OnTruncate func(*TailEvent)
+	OnDelete   func(*TailEvent)
+	OnError    func(*TailEvent)
+}
+
+func (h *Hooks) lookup(t TailEventType) func(*TailEvent) {
+	switch t {
+	case TAIL_CREATE:
+		return h.OnCreate
+	case TAIL_ROTATE:
+		return h.OnRotate
+	case TAIL_TRUNCATE:
+		return h.OnTruncate
+	case TAIL_DELETE:
+		return h.OnDelete
+	case TAIL_ERROR:
+		return h.OnError
+	}
+	return nil
+}
+
+// hookList is shared among a TailName and its clones.
+type hookList struct {
+	mu    sync.Mutex
+	hooks []*Hooks
+}
+
+func (hl *hookList) add(h *Hooks) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	hl.hooks = append(hl.hooks, h)
+}
+
+func (hl *hookList) remove(h *Hooks) bool {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	for i, v := range hl.hooks {
+		if v == h {
+			hl.hooks = append(hl.hooks[:i], hl.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (hl *hookList) emit(ev *TailEvent) {
+	hl.mu.Lock()
+	hooks := make([]*Hooks, len(hl.hooks))
+	copy(hooks, hl.hooks)
+	hl.mu.Unlock()
+
+	for _, h := range hooks {
+		if f := h.lookup(ev.Type); f != nil {
+			f(ev)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package lotf
+
+import (
+	"fmt"
+)
+
+type MarkerType int
+
+const (
+	MARKER_EVENT     MarkerType = iota // lifecycle event of the file, see Marker.Event
+	MARKER_RELOAD                      // lines after this are rebuilt by Tail.Refilter
+	MARKER_SEPARATOR                   // between groups of context lines not adjacent
+)
+
+var markerTypeNames = []string{
+	MARKER_EVENT:     "event",
+	MARKER_RELOAD:    "reload",
+	MARKER_SEPARATOR: "separator",
+}
+
+func (t MarkerType) String() string {
+	if t < 0 || int(t) >= len(markerTypeNames) {
+		return fmt.Sprintf("MarkerType(%d)", int(t))
+	}
+	return markerTypeNames[t]
+}
+
+// Marker is stored in Blockq next to lines, not a line of the file.
+type Marker struct {
+	Type  MarkerType
+	Event *TailEvent
+}
+
+func (m *Marker) String() string {
+	switch m.Type {
+	case MARKER_EVENT:
+		switch m.Event.Type {
+		case TAIL_CREATE:
+			return fmt.Sprintf("file created, inode: %d", m.Event.NewIno)
+		case TAIL_ROTATE:
+			return fmt.Sprintf("rotated, now inode: %d", m.Event.NewIno)
+		case TAIL_TRUNCATE:
+			return "file truncated"
+		case TAIL_DELETE:
+			return "file removed, waiting"
+		}
+		return m.Event.Type.String()
+	case MARKER_RELOAD:
+		return "filter reloaded"
+	case MARKER_SEPARATOR:
+		return "--"
+	}
+	return fmt.Sprintf("MarkerType(%d)", int(m.Type))
+}
+
+// Line is a value of Blockq Element which TailName stores. This is shared
+// among readers so that it should not be modified.
+type Line struct {
+	Text   string
+	Offset int64   // file offset of the line head, see Tail.History
+	Marker *Marker // not nil if this is a marker, Text is empty then
+	Match  *Match  // set if the filter is a Matcher and accepted this line
+}
+
+func (l *Line) IsMarker() bool {
+	return l.Marker != nil
+}
+
+func (l *Line) String() string {
+	if l.Marker != nil {
+		return l.Marker.String()
+	}
+	return l.Text
+}
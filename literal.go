@@ -0,0 +1,323 @@
+package lotf
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// a state of Aho-Corasick automaton, edges are sorted by keys
+type acNode struct {
+	keys []byte
+	next []int32
+	fail int32
+	out  bool  // a pattern ends here or at a state of fail links
+	size int32 // length of the longest pattern of out
+}
+
+func (n *acNode) get(c byte) int32 {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.keys[mid] < c {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < len(n.keys) && n.keys[lo] == c {
+		return n.next[lo]
+	}
+	return 0
+}
+
+// acMatcher finds any of many literal patterns in one pass of a line.
+type acMatcher struct {
+	nodes []acNode
+	root  [256]int32 // edges of root, dense for the most frequent lookup
+	fold  bool       // ASCII case-insensitive
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c | 0x20
+	}
+	return c
+}
+
+// builds the automaton of patterns, empty one is ignored
+func newACMatcher(patterns []string, fold bool) *acMatcher {
+	m := &acMatcher{nodes: []acNode{{}}, fold: fold}
+	edges := []map[byte]int32{{}}
+	for _, p := range patterns {
+		if len(p) == 0 {
+			continue
+		}
+		s := int32(0)
+		for i := 0; i < len(p); i++ {
+			c := p[i]
+			if fold {
+				c = lowerASCII(c)
+			}
+			n, found := edges[s][c]
+			if !found {
+				n = int32(len(m.nodes))
+				m.nodes = append(m.nodes, acNode{})
+				edges = append(edges, make(map[byte]int32))
+				edges[s][c] = n
+			}
+			s = n
+		}
+		m.nodes[s].out = true
+		m.nodes[s].size = int32(len(p))
+	}
+
+	// fail links in breadth first order
+	queue := make([]int32, 0, len(m.nodes))
+	for c, n := range edges[0] {
+		m.root[c] = n
+		queue = append(queue, n)
+	}
+	for ; len(queue) > 0; queue = queue[1:] {
+		s := queue[0]
+		for c, n := range edges[s] {
+			f := m.nodes[s].fail
+			for f != 0 {
+				if _, found := edges[f][c]; found {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			m.nodes[n].fail = edges[f][c]
+			m.nodes[n].out = m.nodes[n].out || m.nodes[m.nodes[n].fail].out
+			if m.nodes[n].size == 0 {
+				m.nodes[n].size = m.nodes[m.nodes[n].fail].size
+			}
+			queue = append(queue, n)
+		}
+	}
+
+	for s, e := range edges {
+		node := &m.nodes[s]
+		for c := 0; c < 256; c++ {
+			if n, found := e[byte(c)]; found {
+				node.keys = append(node.keys, byte(c))
+				node.next = append(node.next, n)
+			}
+		}
+	}
+	return m
+}
+
+// returns the state after c from state
+func (m *acMatcher) next(state int32, c byte) int32 {
+	if m.fold {
+		c = lowerASCII(c)
+	}
+	for {
+		if state == 0 {
+			return m.root[c]
+		}
+		if n := m.nodes[state].get(c); n != 0 {
+			return n
+		}
+		state = m.nodes[state].fail
+	}
+}
+
+// returns true if s contains one of the patterns
+func (m *acMatcher) match(s string) bool {
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		state = m.next(state, s[i])
+		if m.nodes[state].out {
+			return true
+		}
+	}
+	return false
+}
+
+// returns spans of the patterns in s, overlapping ones are joined
+func (m *acMatcher) spans(s string) []Span {
+	var spans []Span
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		state = m.next(state, s[i])
+		if !m.nodes[state].out {
+			continue
+		}
+		start := i + 1 - int(m.nodes[state].size)
+		for len(spans) > 0 && spans[len(spans)-1].End >= start {
+			// the last one overlaps or is adjacent
+			if last := spans[len(spans)-1]; last.Start < start {
+				start = last.Start
+			}
+			spans = spans[:len(spans)-1]
+		}
+		spans = append(spans, Span{start, i + 1})
+	}
+	return spans
+}
+
+// literalFilter is literal: scheme, see LiteralFilter.
+type literalFilter struct {
+	path    string
+	matcher atomic.Value // *acMatcher, swapped by Reload
+}
+
+// LiteralFilter creates Filter from a file of literal strings, one per line,
+// which passes lines containing one of them. This is registered as "literal:"
+// scheme, and is much faster than a filter file of regexps for thousands of
+// keywords like IP addresses or hashes.
+func LiteralFilter(path string) (Filter, error) {
+	m, err := readLiterals(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &literalFilter{path: path}
+	f.matcher.Store(m)
+	return f, nil
+}
+
+func init() {
+	RegisterFilter("literal", LiteralFilter)
+}
+
+func readLiterals(path string) (*acMatcher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newACMatcher(patterns, false), nil
+}
+
+func (f *literalFilter) Filter(line string) bool {
+	return f.matcher.Load().(*acMatcher).match(line)
+}
+
+// Match returns the part of line at the first span as the rule.
+func (f *literalFilter) Match(line string) (bool, *Match) {
+	spans := f.matcher.Load().(*acMatcher).spans(line)
+	if len(spans) == 0 {
+		return false, nil
+	}
+	return true, &Match{Rule: line[spans[0].Start:spans[0].End], Spans: spans}
+}
+
+func (f *literalFilter) Reload() error {
+	m, err := readLiterals(f.path)
+	if err != nil {
+		return err
+	}
+	f.matcher.Store(m)
+	return nil
+}
+
+func (f *literalFilter) Sources() []string {
+	return []string{f.path}
+}
+
+func (f *literalFilter) String() string {
+	return "literal:" + f.path
+}
+
+// returns literals one of which must appear in a string re matches. fold is
+// true if they are lower cased for case-insensitive match.
+func requiredLiterals(re *syntax.Regexp) (lits []string, fold bool, ok bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		s := string(re.Rune)
+		if re.Flags&syntax.FoldCase == 0 {
+			return []string{s}, false, true
+		}
+		for _, r := range re.Rune {
+			// k and s also match KELVIN SIGN and LONG S
+			if r >= utf8.RuneSelf || strings.ContainsRune("kKsS", r) {
+				return nil, false, false
+			}
+		}
+		return []string{strings.ToLower(s)}, true, true
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// the set whose shortest one is the longest
+		best := -1
+		for _, sub := range re.Sub {
+			l, f, o := requiredLiterals(sub)
+			if !o {
+				continue
+			}
+			shortest := len(l[0])
+			for _, s := range l[1:] {
+				if len(s) < shortest {
+					shortest = len(s)
+				}
+			}
+			if shortest > best {
+				best, lits, fold, ok = shortest, l, f, true
+			}
+		}
+		return
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			l, f, o := requiredLiterals(sub)
+			if !o {
+				return nil, false, false
+			}
+			lits = append(lits, l...)
+			fold = fold || f
+		}
+		return lits, fold, true
+	}
+	return nil, false, false
+}
+
+// literalRegexp is regexp with a prefilter of required literals, which skips
+// running regexp on a line that can not match.
+type literalRegexp struct {
+	re  *regexp.Regexp
+	pre *acMatcher // nil if no literal is required
+}
+
+func compileLiteralRegexp(expr string) (*literalRegexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	lr := &literalRegexp{re: re}
+	if parsed, err := syntax.Parse(expr, syntax.Perl); err == nil {
+		if lits, fold, ok := requiredLiterals(parsed.Simplify()); ok {
+			lr.pre = newACMatcher(lits, fold)
+		}
+	}
+	return lr, nil
+}
+
+func (lr *literalRegexp) MatchString(s string) bool {
+	if lr.pre != nil && !lr.pre.match(s) {
+		return false
+	}
+	return lr.re.MatchString(s)
+}
+
+func (lr *literalRegexp) String() string {
+	return lr.re.String()
+}
@@ -0,0 +1,194 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestACMatcher(t *testing.T) {
+	m := newACMatcher([]string{"he", "she", "his", "hers", ""}, false)
+	for s, expect := range map[string]bool{
+		"ushers": true,
+		"ahishe": true,
+		"hi":     false,
+		"sh":     false,
+		"":       false,
+		"HERS":   false,
+	} {
+		if m.match(s) != expect {
+			t.Fatalf("match(%q) expect: %v", s, expect)
+		}
+	}
+	// needs fail links to find "bcd" after "abcx" failed
+	m = newACMatcher([]string{"abcx", "bcd", "Cd"}, true)
+	for s, expect := range map[string]bool{
+		"abcd":  true,
+		"xABCD": true,
+		"abcy":  false,
+		"ac d":  false,
+	} {
+		if m.match(s) != expect {
+			t.Fatalf("fold match(%q) expect: %v", s, expect)
+		}
+	}
+}
+
+func TestLiteralRegexp(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		pre  bool // expect a prefilter
+	}{
+		{"error", true},
+		{"^(foo|bar)[0-9]+baz", true},
+		{"(?i)timeout", true},
+		{"(?i)ssh", false}, // LONG S
+		{"a*", false},
+		{"(foo|.*)", false},
+		{"é+", true},
+	} {
+		lr, err := compileLiteralRegexp(c.expr)
+		if err != nil {
+			t.Fatalf("compile(%s): %s", c.expr, err)
+		}
+		if (lr.pre != nil) != c.pre {
+			t.Fatalf("expr: %s, expect prefilter: %v", c.expr, c.pre)
+		}
+		re := regexp.MustCompile(c.expr)
+		for _, s := range []string{"error!", "foo12baz", "bar1baz", "TimeOut", "SSH", "ſsh", "aaa", "éé", "nothing"} {
+			if lr.MatchString(s) != re.MatchString(s) {
+				t.Fatalf("expr: %s, differs from regexp on: %q", c.expr, s)
+			}
+		}
+	}
+}
+
+func TestLiteralFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "literals")
+	if err := ioutil.WriteFile(fname, []byte("10.0.0.1\n\nevil.example\n"), 0666); err != nil {
+		t.Fatalf("failed to write literals: %s", err)
+	}
+	f, err := NewFilter("literal:" + fname)
+	if err != nil {
+		t.Fatalf("NewFilter: %s", err)
+	}
+	if !f.Filter("connect from 10.0.0.1:22") || !f.Filter("GET http://evil.example/") || f.Filter("10.0.0.2") {
+		t.Fatalf("unexpected literal filter result")
+	}
+	if err := ioutil.WriteFile(fname, []byte("10.0.0.2\n"), 0666); err != nil {
+		t.Fatalf("failed to write literals: %s", err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if f.Filter("10.0.0.1") || !f.Filter("10.0.0.2") {
+		t.Fatalf("expect reloaded literals")
+	}
+}
+
+// IP addresses and hashes like IOC lists, and lines mostly not matching
+func benchmarkFilter(b *testing.B, create func(string, bool) (func(string) bool, error)) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		b.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "iocs")
+	var iocs []string
+	for i := 0; i < 1000; i++ {
+		iocs = append(iocs, fmt.Sprintf("192\\.168\\.%d\\.%d", i/256, i%256))
+		iocs = append(iocs, fmt.Sprintf("%08x%08x", i*2654435761, i))
+	}
+	if err := ioutil.WriteFile(fname, []byte(strings.Join(iocs, "\n")), 0666); err != nil {
+		b.Fatalf("failed to write iocs: %s", err)
+	}
+	filter, err := create(fname, false)
+	if err != nil {
+		b.Fatalf("create filter: %s", err)
+	}
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("Jan  2 15:04:05 host sshd[%d]: Accepted publickey for user from 10.1.%d.%d port 22", i, i, i)
+	}
+	lines[50] = "Jan  2 15:04:05 host sshd[1]: connection from 192.168.3.7 port 22"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			filter(line)
+		}
+	}
+}
+
+// factory of filterFactory without the match
+func passOnly(factory func(string, bool) (*fileFilter, error)) func(string, bool) (func(string) bool, error) {
+	return func(path string, inverse bool) (func(string) bool, error) {
+		f, err := factory(path, inverse)
+		if err != nil {
+			return nil, err
+		}
+		f.rules.counters = takeCounters(nil, nil, f.rules.lines)
+		return func(line string) bool {
+			pass, _ := f.eval(line, STATS_TOP, false)
+			return pass
+		}, nil
+	}
+}
+
+func BenchmarkJoinedExpFilter(b *testing.B) {
+	benchmarkFilter(b, passOnly(joinedExpFilter))
+}
+
+func BenchmarkPerExpFilter(b *testing.B) {
+	benchmarkFilter(b, passOnly(perExpFilter))
+}
+
+// factory of filters as NewFilter creates them, the file is taken as literal
+// strings without regexp escapes if unescape is true
+func specFilter(scheme string, unescape bool) func(string, bool) (func(string) bool, error) {
+	return func(path string, inverse bool) (func(string) bool, error) {
+		if unescape {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			path += ".literal"
+			if err := ioutil.WriteFile(path, []byte(strings.Replace(string(content), "\\", "", -1)), 0666); err != nil {
+				return nil, err
+			}
+		}
+		if inverse {
+			scheme = "!" + scheme
+		}
+		f, err := NewFilter(scheme + path)
+		if err != nil {
+			return nil, err
+		}
+		return f.Filter, nil
+	}
+}
+
+// the filters of a spec, with stats counted
+func BenchmarkNewFilter(b *testing.B) {
+	for _, c := range []struct {
+		scheme   string
+		unescape bool
+	}{
+		{"file:", false},
+		{"perexp:", false},
+		{"literal:", true},
+	} {
+		b.Run(c.scheme, func(b *testing.B) {
+			benchmarkFilter(b, specFilter(c.scheme, c.unescape))
+		})
+	}
+}
@@ -9,16 +9,104 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// the number of lines to buffer on start if not specified in triplet
+const START_MAXLINES = 65536
+
+var sinceFlag, linesFlag, bytesFlag, followFlag string
+
+func init() {
+	flag.StringVar(&sinceFlag, "since", "", "start from the time instead of last lines, e.g. 2006-01-02 15:04:05 or 15:04:05 of today")
+	flag.StringVar(&linesFlag, "n", "", "+K to start from line K instead of last lines")
+	flag.StringVar(&followFlag, "follow", "name", "name to reopen after rotation, or descriptor to keep the opened file")
+	flag.StringVar(&bytesFlag, "c", "", "N to start from last N bytes, +N from byte N (1 origin) instead of last lines")
+}
+
+// sets opts start position by -n and -c flags, in the same manner as tail
+func parseStart(opts *lotf.TailOptions) error {
+	var err error
+	if len(linesFlag) > 0 {
+		if !strings.HasPrefix(linesFlag, "+") {
+			return fmt.Errorf("invalid -n, last lines are specified in triplet: %s", linesFlag)
+		}
+		opts.From = lotf.FROM_LINE
+		if opts.Start, err = strconv.ParseInt(linesFlag[1:], 0, 64); err != nil {
+			return err
+		}
+	}
+	if len(bytesFlag) > 0 {
+		if opts.From != lotf.FROM_LAST_LINES {
+			return fmt.Errorf("both of -n and -c are specified")
+		}
+		opts.From = lotf.FROM_LAST_BYTES
+		s := bytesFlag
+		if strings.HasPrefix(s, "+") {
+			opts.From = lotf.FROM_OFFSET
+			s = s[1:]
+		}
+		if opts.Start, err = strconv.ParseInt(s, 0, 64); err != nil {
+			return err
+		}
+		if opts.From == lotf.FROM_OFFSET && opts.Start > 0 {
+			opts.Start--
+		}
+	}
+	return nil
+}
+
+// accepts a few layouts in local time, only clock means today
+func parseSince(s string) (time.Time, error) {
+	var err error
+	var t time.Time
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err = time.ParseInLocation("15:04:05", s, time.Local); err == nil {
+		y, m, d := time.Now().Date()
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+	}
+	return t, err
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of: %s <options> <triplet> [<triplet> <triplet> ...]\n", os.Args[0])
 	fmt.Fprintln(os.Stderr, " where options are:")
 	flag.PrintDefaults()
 	fmt.Fprintln(os.Stderr, " where triplet is colon separated <file>:<filter>:<lines>")
-	fmt.Fprintln(os.Stderr, "  file:   target file name")
+	fmt.Fprintln(os.Stderr, "  file:   target file name, - for stdin")
 	fmt.Fprintln(os.Stderr, "  filter: filter file name")
-	fmt.Fprintln(os.Stderr, "  lines:  number of last lines to print")
+	fmt.Fprintln(os.Stderr, "  lines:  number of last lines to print, or max lines to buffer")
+	fmt.Fprintf(os.Stderr, "          on start with -since, -n or -c, %d if 0\n", START_MAXLINES)
+}
+
+// adds stdin or non-regular file, FIFO or character device, as a stream which
+// buffers START_MAXLINES at least, since it may be read faster than printing.
+func add(tw *lotf.TailWatcher, arg *Arg, maxlines int, opts *lotf.TailOptions) (lotf.Tail, error) {
+	if maxlines < START_MAXLINES && (arg.fname == "-" || !isRegular(arg.fname)) {
+		maxlines = START_MAXLINES
+	}
+	if arg.fname == "-" {
+		return tw.AddFile(os.Stdin, maxlines, arg.filter, int(arg.lines))
+	}
+	if !isRegular(arg.fname) {
+		file, err := os.Open(arg.fname)
+		if err != nil {
+			return nil, err
+		}
+		return tw.AddFile(file, maxlines, arg.filter, int(arg.lines))
+	}
+	return tw.AddOptions(arg.fname, maxlines, arg.filter, int(arg.lines), opts)
+}
+
+// returns true if name is a regular file or can not stat
+func isRegular(name string) bool {
+	fi, err := os.Stat(name)
+	return err != nil || fi.Mode().IsRegular()
 }
 
 type Arg struct {
@@ -37,19 +125,46 @@ func main() {
 	}
 
 	var err error
+	opts := &lotf.TailOptions{}
+	if len(sinceFlag) > 0 {
+		if opts.Since, err = parseSince(sinceFlag); err != nil {
+			glog.Fatalf("invalid time: %s", sinceFlag)
+		}
+	}
+	if err = parseStart(opts); err != nil {
+		glog.Fatalf("invalid start position: %s", err)
+	}
+	switch followFlag {
+	case "name":
+		opts.Follow = lotf.FOLLOW_NAME
+	case "descriptor":
+		opts.Follow = lotf.FOLLOW_DESCRIPTOR
+	default:
+		glog.Fatalf("invalid follow: %s", followFlag)
+	}
+	// lines in triplet limits the number of lines to buffer on start
+	startFrom := !opts.Since.IsZero() || opts.From != lotf.FROM_LAST_LINES
 
 	argl := list.New()
-	for _, s := range os.Args[1:] {
-		args := strings.Split(s, ":")
+	for _, s := range flag.Args() {
+		// filter spec may contain ':', lines are after the last one only if
+		// they are a number or empty
+		args := strings.SplitN(s, ":", 2)
 		arg := &Arg{args[0], nil, 0}
-		if len(args) > 1 && len(args[1]) > 0 {
-			if arg.filter, err = lotf.RegexpFilter(args[1]); err != nil {
-				glog.Fatalf("could not create filter from: %s, error: %s", args[1], err)
+		var spec string
+		if len(args) > 1 {
+			spec = args[1]
+		}
+		if i := strings.LastIndexByte(spec, ':'); i >= 0 {
+			if n := spec[i+1:]; len(n) == 0 {
+				spec = spec[:i]
+			} else if lines, err := strconv.ParseUint(n, 10, 64); err == nil {
+				spec, arg.lines = spec[:i], lines
 			}
 		}
-		if len(args) > 2 {
-			if arg.lines, err = strconv.ParseUint(args[2], 0, 64); err != nil {
-				glog.Fatalf("invalid number of lines: %s", args[2])
+		if len(spec) > 0 {
+			if arg.filter, err = lotf.NewFilter(spec); err != nil {
+				glog.Fatalf("could not create filter from: %s, error: %s", spec, err)
 			}
 		}
 		argl.PushBack(arg)
@@ -62,19 +177,27 @@ func main() {
 	go func() {
 		for err = range tw.Error {
 			fmt.Printf("ERROR: %s\n", err)
-			os.Exit(1)
+			if lotf.IsFatal(err) {
+				os.Exit(1)
+			}
 		}
 	}()
 
+	// exits after all of tails end, stdin for example
 	ch := make(chan string)
+	var wg sync.WaitGroup
 	for e := argl.Front(); e != nil; e = e.Next() {
 		arg := e.Value.(*Arg)
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			maxlines := 1
 			if arg.lines > 0 {
 				maxlines = int(arg.lines)
+			} else if startFrom {
+				maxlines = START_MAXLINES
 			}
-			tail, err := tw.Add(arg.fname, maxlines, arg.filter, int(arg.lines))
+			tail, err := add(tw, arg, maxlines, opts)
 			if err != nil {
 				glog.Fatalf("could not add %s to watcher: %s", arg.fname, err)
 			}
@@ -83,6 +206,10 @@ func main() {
 			}
 		}()
 	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
 
 	for line := range ch {
 		fmt.Println(line)
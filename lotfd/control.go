@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/chamaken/lotf"
+	"github.com/golang/glog"
+	"net"
+	"os"
+	"strings"
+)
+
+// ControlServer accepts line requests on a unix domain socket, "pause <path>",
+// "resume <path>" or "stats <path>", and replies "ok" or "error: <reason>" for
+// each, or a line of JSON array of the filter stats for stats.
+type ControlServer struct {
+	watcher  *lotf.TailWatcher
+	filters  map[string][]lotf.Filter // by path for stats
+	listener net.Listener
+	done     chan bool
+}
+
+func NewControlServer(watcher *lotf.TailWatcher, path string, filters map[string][]lotf.Filter) (*ControlServer, error) {
+	os.Remove(path) // left by previous run
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &ControlServer{watcher, filters, listener, make(chan bool, 1)}, nil
+}
+
+// returns the reply, empty for "ok"
+func (svr *ControlServer) request(line string) (string, error) {
+	args := strings.Fields(line)
+	if len(args) != 2 {
+		return "", fmt.Errorf("invalid request: %s", line)
+	}
+	switch args[0] {
+	case "pause":
+		return "", svr.watcher.Pause(args[1])
+	case "resume":
+		return "", svr.watcher.Resume(args[1])
+	case "stats":
+		return svr.stats(args[1])
+	}
+	return "", fmt.Errorf("unknown request: %s", args[0])
+}
+
+func (svr *ControlServer) stats(path string) (string, error) {
+	filters, found := svr.filters[path]
+	if !found {
+		return "", fmt.Errorf("unknown path: %s", path)
+	}
+	stats := make([]*lotf.FilterStats, 0)
+	for _, filter := range filters {
+		if s := lotf.FilterStatsOf(filter); s != nil {
+			stats = append(stats, s)
+		}
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+func (svr *ControlServer) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		reply, err := svr.request(scanner.Text())
+		if err != nil {
+			glog.Infof("control request: %s", err)
+			reply = fmt.Sprintf("error: %s\n", err)
+		} else if len(reply) == 0 {
+			reply = "ok\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			glog.Infof("write error to control: %s", err)
+			return
+		}
+	}
+}
+
+func (svr *ControlServer) Run(errch chan<- error) {
+	for {
+		conn, err := svr.listener.Accept()
+		select {
+		case <-svr.done:
+			glog.Info("exit control Run gracefully")
+			return
+		default:
+		}
+		if err != nil {
+			glog.Errorf("control accept: %s", err)
+			errch <- err
+			return
+		}
+		go svr.serve(conn)
+	}
+}
+
+// closing the listener also removes the socket file
+func (svr *ControlServer) Done() error {
+	svr.done <- true
+	if err := svr.listener.Close(); err != nil {
+		glog.Infof("failed to close: %s", err)
+		return err
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"github.com/chamaken/lotf"
 	"github.com/golang/glog"
 	"io"
@@ -9,30 +8,34 @@ import (
 )
 
 type DgramServer struct {
-	tail lotf.Tail
-	conn io.WriteCloser
+	tail   lotf.Tail
+	conn   io.WriteCloser
+	format formatter
 }
 
-func NewUDPServer(t lotf.Tail, raddr *net.UDPAddr) (*DgramServer, error) {
+func NewUDPServer(t lotf.Tail, raddr *net.UDPAddr, format formatter) (*DgramServer, error) {
 	conn, err := net.DialUDP("udp4", nil, raddr)
 	if err != nil {
 		return nil, err
 	}
-	return &DgramServer{t, io.WriteCloser(conn)}, nil
+	return &DgramServer{t, io.WriteCloser(conn), format}, nil
 }
 
-func NewUnixgramServer(t lotf.Tail, raddr *net.UnixAddr) (*DgramServer, error) {
+func NewUnixgramServer(t lotf.Tail, raddr *net.UnixAddr, format formatter) (*DgramServer, error) {
 	conn, err := net.DialUnix("unixgram", nil, raddr)
 	if err != nil {
 		return nil, err
 	}
-	return &DgramServer{t, io.WriteCloser(conn)}, nil
+	return &DgramServer{t, io.WriteCloser(conn), format}, nil
 }
 
 // loop will stop by Tail.Done()
 func (svr *DgramServer) Run(errch chan<- error) {
-	for s := svr.tail.WaitNext(); s != nil; s = svr.tail.WaitNext() {
-		b := []byte(fmt.Sprintf("%s\n", *s))
+	for l := svr.tail.WaitNextLine(); l != nil; l = svr.tail.WaitNextLine() {
+		b := svr.format(l)
+		if b == nil {
+			continue
+		}
 		if n, err := svr.conn.Write(b); err != nil {
 			glog.Errorf("connection write: %s", err)
 			errch <- err
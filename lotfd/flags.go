@@ -9,32 +9,60 @@ import (
 	"io"
 	"net"
 	"os"
+	"strings"
 )
 
 var rcfileFlag string
 var pidfileFlag string
 var lastlinesFlag int
+var maxopenFlag int
+var controlFlag string
+var autoreloadFlag bool
 
 func init() {
 	flag.StringVar(&rcfileFlag, "c", "lotfd.json", "config filename")
 	flag.StringVar(&pidfileFlag, "p", "", "pid filename")
 	flag.IntVar(&lastlinesFlag, "n", 10, "last lines on startup")
+	flag.IntVar(&maxopenFlag, "maxopen", 0, "max number of files kept opened, 0 for no limit")
+	flag.StringVar(&controlFlag, "control", "", "unix socket path to accept pause and resume requests")
+	flag.BoolVar(&autoreloadFlag, "autoreload", false, "reload filter and mapper files on change")
 }
 
 type RCEntry struct {
 	File     string
+	Syslog   string
+	Command  []string
 	Filter   string
+	Mapper   string
 	Udpaddr  string
 	Tcpaddr  string
 	Buflines int
+	Markers  bool
+	Rotated  bool
+	Format   string
+	Rawlines int
+	Dedup    string
+	Before   int
+	After    int
 }
 
 type LTFResource struct {
 	filename string
+	syslog   bool     // filename is syslog url
+	command  []string // filename is command line joined if not nil
 	filter   lotf.Filter
+	mapper   lotf.Mapper
 	tcpaddr  *net.TCPAddr
 	udpaddr  *net.UDPAddr
 	buflines int
+	markers  bool
+	rotated  bool
+	format   formatter
+	frame    framer // nil if the format does not allow scrollback
+	rawlines int    // lines kept before filtering to rebuild on reload
+	dedup    *lotf.Dedup
+	before   int // context lines around the ones filter accepts
+	after    int
 }
 
 func makeResources(fname string) ([]LTFResource, error) {
@@ -58,9 +86,45 @@ func makeResources(fname string) ([]LTFResource, error) {
 	t := make([]LTFResource, len(s))
 	for i, e := range s {
 		t[i].filename = e.File
+		if len(e.Syslog) > 0 {
+			if len(e.File) > 0 {
+				return nil, errors.New(fmt.Sprintf("both of file and syslog specified: %s", e.File))
+			}
+			t[i].filename = e.Syslog
+			t[i].syslog = true
+		}
+		if len(e.Command) > 0 {
+			if len(e.File) > 0 || len(e.Syslog) > 0 {
+				return nil, errors.New(fmt.Sprintf("command and file or syslog specified: %s", e.Command))
+			}
+			t[i].filename = strings.Join(e.Command, " ")
+			t[i].command = e.Command
+		}
 		t[i].buflines = e.Buflines
+		t[i].markers = e.Markers
+		t[i].rotated = e.Rotated
+		t[i].rawlines = e.Rawlines
+		t[i].before = e.Before
+		t[i].after = e.After
+		if f, found := formatters[e.Format]; !found {
+			return nil, errors.New(fmt.Sprintf("unknown format: %s", e.Format))
+		} else {
+			t[i].format = f
+		}
+		t[i].frame = framers[e.Format]
 		if len(e.Filter) > 0 {
-			if t[i].filter, err = lotf.RegexpFilter(e.Filter); err != nil {
+			if t[i].filter, err = lotf.NewFilter(e.Filter); err != nil {
+				return nil, err
+			}
+		}
+		if len(e.Mapper) > 0 {
+			if t[i].mapper, err = lotf.NewMapper(e.Mapper); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(e.Dedup) > 0 {
+			if t[i].dedup, err = lotf.NewDedup(e.Dedup); err != nil {
 				return nil, err
 			}
 		}
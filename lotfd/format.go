@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/chamaken/lotf"
+)
+
+// formatter returns bytes to write for a line, nil if it should be skipped.
+type formatter func(*lotf.Line) []byte
+
+// framer returns bytes to write before scrollback lines if begin, or after
+// them, see session.request.
+type framer func(begin bool) []byte
+
+type jsonMarker struct {
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	OldIno    uint64 `json:"oldino,omitempty"`
+	NewIno    uint64 `json:"newino,omitempty"`
+	OldOffset int64  `json:"oldoffset,omitempty"`
+	NewOffset int64  `json:"newoffset,omitempty"`
+}
+
+type jsonMatch struct {
+	Rule  string   `json:"rule"`
+	Spans [][2]int `json:"spans,omitempty"` // byte offsets [start, end)
+}
+
+type jsonLine struct {
+	Line       *string     `json:"line,omitempty"`
+	Offset     *int64      `json:"offset,omitempty"`
+	Marker     *jsonMarker `json:"marker,omitempty"`
+	Match      *jsonMatch  `json:"match,omitempty"`
+	Scrollback string      `json:"scrollback,omitempty"` // begin or end
+}
+
+// plain text, marker is not written since it can not be told apart except
+// "--" between context lines as grep
+func textFormat(l *lotf.Line) []byte {
+	if l.IsMarker() {
+		if l.Marker.Type == lotf.MARKER_SEPARATOR {
+			return []byte("--\n")
+		}
+		return nil
+	}
+	return []byte(fmt.Sprintf("%s\n", l.Text))
+}
+
+// a JSON object per line, {"line": "..."} or {"marker": {...}}
+func jsonFormat(l *lotf.Line) []byte {
+	v := &jsonLine{}
+	if l.IsMarker() {
+		v.Marker = &jsonMarker{Type: l.Marker.Type.String(), Text: l.Marker.String()}
+		if ev := l.Marker.Event; ev != nil {
+			v.Marker.Type = ev.Type.String()
+			v.Marker.OldIno = ev.OldIno
+			v.Marker.NewIno = ev.NewIno
+			v.Marker.OldOffset = ev.OldOffset
+			v.Marker.NewOffset = ev.NewOffset
+		}
+	} else {
+		s, offset := l.Text, l.Offset
+		v.Line = &s
+		v.Offset = &offset
+		if m := l.Match; m != nil {
+			v.Match = &jsonMatch{Rule: m.Rule}
+			for _, span := range m.Spans {
+				v.Match.Spans = append(v.Match.Spans, [2]int{span.Start, span.End})
+			}
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil { // never happen
+		return nil
+	}
+	return append(b, '\n')
+}
+
+// {"scrollback": "begin"} or {"scrollback": "end"}
+func jsonFrame(begin bool) []byte {
+	v := &jsonLine{Scrollback: "end"}
+	if begin {
+		v.Scrollback = "begin"
+	}
+	b, err := json.Marshal(v)
+	if err != nil { // never happen
+		return nil
+	}
+	return append(b, '\n')
+}
+
+var formatters = map[string]formatter{
+	"":     textFormat,
+	"text": textFormat,
+	"json": jsonFormat,
+}
+
+// text has no framer since any text can be a line, scrollback lines can not be
+// told apart from the ones following
+var framers = map[string]framer{
+	"json": jsonFrame,
+}
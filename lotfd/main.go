@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"github.com/chamaken/lotf"
 	"github.com/golang/glog"
@@ -13,29 +12,45 @@ import (
 type resource struct {
 	tail   lotf.Tail
 	filter lotf.Filter
+	mapper lotf.Mapper
 	ssvr   *StreamServer
 	usvr   *DgramServer
 }
 
-func sighandler(watcher *lotf.TailWatcher, rcs []resource, errch chan<- error) {
+// errors of reload and shutdown are sent to errch to be logged, and done is
+// sent on SIGINT or SIGTERM after all is closed
+func sighandler(watcher *lotf.TailWatcher, rcs []resource, csvr *ControlServer, errch chan<- error, done chan<- bool) {
 	sigch := make(chan os.Signal, 1)
 	signal.Notify(sigch)
 
 	for s := range sigch {
 		switch s {
 		case syscall.SIGUSR1:
-			// reload all filter
+			// reload all filter and mapper
 			for _, r := range rcs {
 				if r.filter != nil {
 					if err := r.filter.Reload(); err != nil {
 						errch <- err
 					}
 				}
+				if r.mapper != nil {
+					if err := r.mapper.Reload(); err != nil {
+						errch <- err
+					}
+				}
+				if err := r.tail.Refilter(); err != nil && err != lotf.ErrorNoRawLines {
+					errch <- err
+				}
 			}
 
 		case syscall.SIGINT:
 			fallthrough
 		case syscall.SIGTERM:
+			if csvr != nil {
+				if err := csvr.Done(); err != nil {
+					errch <- err
+				}
+			}
 			for _, r := range rcs {
 				if r.usvr != nil {
 					if err := r.usvr.Done(); err != nil {
@@ -54,8 +69,7 @@ func sighandler(watcher *lotf.TailWatcher, rcs []resource, errch chan<- error) {
 			if err := watcher.Close(); err != nil {
 				errch <- err
 			}
-			errch <- errors.New("graceful exit by SIGTERM")
-			break
+			done <- true
 
 		default:
 			glog.Infof("ignore sighanl: %s", s)
@@ -63,6 +77,27 @@ func sighandler(watcher *lotf.TailWatcher, rcs []resource, errch chan<- error) {
 	}
 }
 
+// adds the file, syslog receiver or command of rc to watcher
+func addTail(watcher *lotf.TailWatcher, rc LTFResource, nlines int) (lotf.Tail, error) {
+	opts := &lotf.TailOptions{Markers: rc.markers, RotatedHistory: rc.rotated, Mapper: rc.mapper, RawLines: rc.rawlines, Dedup: rc.dedup,
+		Before: rc.before, After: rc.after}
+	if rc.command != nil {
+		src, err := lotf.NewCommandSource(rc.command)
+		if err != nil {
+			return nil, err
+		}
+		return watcher.AddSourceOptions(src, nlines, rc.filter, opts)
+	}
+	if rc.syslog {
+		src, err := lotf.NewSyslogSource(rc.filename)
+		if err != nil {
+			return nil, err
+		}
+		return watcher.AddSourceOptions(src, nlines, rc.filter, opts)
+	}
+	return watcher.AddOptions(rc.filename, nlines, rc.filter, rc.buflines, opts)
+}
+
 func main() {
 	watcher, err := lotf.NewTailWatcher()
 	if err != nil {
@@ -76,8 +111,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	watcher.SetMaxOpen(maxopenFlag)
+
 	errch := make(chan error, 512) // XXX: magic number
 	rcs := make([]resource, len(flags))
+	filters := make(map[string][]lotf.Filter) // by path for control stats
 	for i, rc := range flags {
 		if rc.tcpaddr == nil && rc.udpaddr == nil {
 			fmt.Fprintf(os.Stderr, "error - no inet4 server specified\n")
@@ -85,15 +123,27 @@ func main() {
 		}
 
 		glog.Infof("adding watch - path: %s, filter: %s", rc.filename, rc.filter)
-		if rcs[i].tail, err = watcher.Add(rc.filename, nlines, rc.filter, rc.buflines); err != nil {
+		if rcs[i].tail, err = addTail(watcher, rc, nlines); err != nil {
 			glog.Fatalf("could not watch: %s\n", err)
 		}
 		rcs[i].filter = rc.filter
+		rcs[i].mapper = rc.mapper
+		filters[rc.filename] = append(filters[rc.filename], rc.filter)
+		if autoreloadFlag {
+			for _, r := range []lotf.Reloader{rc.filter, rc.mapper} {
+				if r == nil {
+					continue
+				}
+				if err := watcher.WatchReload(r); err != nil {
+					glog.Fatalf("could not watch reload: %s\n", err)
+				}
+			}
+		}
 		glog.Infof("watch added - path: %s, filter: %s", rc.filename, rc.filter)
 
 		if rc.tcpaddr != nil {
 			glog.Infof("starting TCP service - addr: %v)", rc.tcpaddr)
-			if rcs[i].ssvr, err = NewTCPServer(rcs[i].tail, rc.tcpaddr); err != nil {
+			if rcs[i].ssvr, err = NewTCPServer(rcs[i].tail, rc.tcpaddr, rc.filter, rc.format, rc.frame); err != nil {
 				fmt.Fprintf(os.Stderr, "error - could not start TCP service: %s\n", err)
 				os.Exit(1)
 			}
@@ -101,7 +151,7 @@ func main() {
 		}
 		if rc.udpaddr != nil {
 			glog.Infof("starting UDP service - addr: %v", rc.udpaddr)
-			if rcs[i].usvr, err = NewUDPServer(rcs[i].tail, rc.udpaddr); err != nil {
+			if rcs[i].usvr, err = NewUDPServer(rcs[i].tail, rc.udpaddr, rc.format); err != nil {
 				fmt.Fprintf(os.Stderr, "error - could not start UDP service: %s\n", err)
 				os.Exit(1)
 			}
@@ -109,12 +159,40 @@ func main() {
 		}
 	}
 
+	var csvr *ControlServer
+	if len(controlFlag) > 0 {
+		glog.Infof("starting control service - path: %s", controlFlag)
+		if csvr, err = NewControlServer(watcher, controlFlag, filters); err != nil {
+			fmt.Fprintf(os.Stderr, "error - could not start control service: %s\n", err)
+			os.Exit(1)
+		}
+		go csvr.Run(errch)
+	}
+
 	// signal handler
-	go sighandler(watcher, rcs, errch)
+	done := make(chan bool, 1)
+	go sighandler(watcher, rcs, csvr, errch, done)
 
 	// daemonize?
-	for err := range errch {
-		glog.Errorf("%s", err)
-		break
+	// errors of servers, control and reload are just logged, only fatal
+	// errors of watcher stop
+	werrch := watcher.Error
+	for {
+		select {
+		case err := <-errch:
+			glog.Errorf("%s", err)
+		case err, ok := <-werrch:
+			if !ok { // closed on SIGTERM
+				werrch = nil
+				continue
+			}
+			glog.Errorf("%s", err)
+			if lotf.IsFatal(err) {
+				return
+			}
+		case <-done:
+			glog.Info("graceful exit by SIGTERM")
+			return
+		}
 	}
 }
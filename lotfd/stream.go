@@ -1,35 +1,121 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
 	"github.com/chamaken/lotf"
 	"github.com/golang/glog"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type StreamServer struct {
 	tail     lotf.Tail
+	filter   lotf.Filter
 	listener *net.TCPListener
 	done     chan bool
+	format   formatter
+	frame    framer
 }
 
-func NewTCPServer(t lotf.Tail, addr *net.TCPAddr) (*StreamServer, error) {
+func NewTCPServer(t lotf.Tail, addr *net.TCPAddr, filter lotf.Filter, format formatter, frame framer) (*StreamServer, error) {
 	listener, err := net.ListenTCP("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return &StreamServer{t, listener, make(chan bool, 1)}, nil
+	return &StreamServer{t, filter, listener, make(chan bool, 1), format, frame}, nil
 }
 
-func serve(conn net.Conn, t lotf.Tail, errch chan<- error) {
+// a connection, written from both of the tail and client requests
+type session struct {
+	conn   net.Conn
+	tail   lotf.Tail
+	filter lotf.Filter
+	format formatter
+	frame  framer     // nil if scrollback is not allowed
+	mu     sync.Mutex // to sync conn write and oldest
+	oldest *int64     // offset of the oldest line written
+}
+
+func (s *session) write(l *lotf.Line) error {
+	return s.writeBytes(s.format(l))
+}
+
+func (s *session) writeBytes(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	if n, err := s.conn.Write(b); err != nil {
+		glog.Errorf("write error to [%s]: %s", s.conn.RemoteAddr(), err)
+		return err
+	} else if n != len(b) {
+		glog.Infof("could not write at once, writing: %d, written: %d", len(b), n)
+	}
+	return nil
+}
+
+// handles client requests, only "scrollback <number of lines>" for now which
+// writes lines older than written ones between the frames, so that they can be
+// told apart from the ones following.
+func (s *session) request() {
+	scanner := bufio.NewScanner(s.conn)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) != 2 || args[0] != "scrollback" {
+			glog.Infof("invalid request from [%s]: %s", s.conn.RemoteAddr(), scanner.Text())
+			continue
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			glog.Infof("invalid request from [%s]: %s", s.conn.RemoteAddr(), scanner.Text())
+			continue
+		}
+		if s.frame == nil {
+			glog.Infof("scrollback is not allowed in the format, from [%s]", s.conn.RemoteAddr())
+			continue
+		}
+
+		s.mu.Lock()
+		if s.oldest == nil { // nothing has been written
+			s.mu.Unlock()
+			continue
+		}
+		lines, err := s.tail.History(*s.oldest, n, s.filter)
+		if err != nil {
+			glog.Errorf("history for [%s]: %s", s.conn.RemoteAddr(), err)
+		}
+		err = s.writeBytes(s.frame(true))
+		for _, l := range lines {
+			if err != nil {
+				break
+			}
+			err = s.write(l)
+		}
+		if err == nil {
+			s.writeBytes(s.frame(false))
+		}
+		if len(lines) > 0 {
+			s.oldest = &lines[0].Offset
+		}
+		s.mu.Unlock()
+	}
+}
+
+func serve(conn net.Conn, t lotf.Tail, filter lotf.Filter, format formatter, frame framer, errch chan<- error) {
 	defer conn.Close()
-	for s := t.WaitNext(); s != nil; s = t.WaitNext() {
-		b := []byte(fmt.Sprintf("%s\n", *s))
-		if n, err := conn.Write(b); err != nil {
-			glog.Errorf("write error to [%s]: %s", conn.RemoteAddr(), err)
+	s := &session{conn: conn, tail: t, filter: filter, format: format, frame: frame}
+	go s.request()
+	for l := t.WaitNextLine(); l != nil; l = t.WaitNextLine() {
+		s.mu.Lock()
+		if s.oldest == nil && !l.IsMarker() {
+			offset := l.Offset
+			s.oldest = &offset
+		}
+		err := s.write(l)
+		s.mu.Unlock()
+		if err != nil {
 			break
-		} else if n != len(b) {
-			glog.Infof("could not write at once, writing: %d, written: %d", len(b), n)
 		}
 	}
 }
@@ -49,7 +135,7 @@ func (svr *StreamServer) Run(errch chan<- error) {
 			glog.Errorf("listener accept: %s", err)
 			errch <- err
 		} else {
-			go serve(conn, svr.tail.Clone(), errch)
+			go serve(conn, svr.tail.Clone(), svr.filter, svr.format, svr.frame, errch)
 		}
 	}
 	glog.Info("exit Run gracefully")
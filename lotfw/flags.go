@@ -8,6 +8,7 @@ import (
 	"github.com/chamaken/lotf"
 	"io"
 	"os"
+	"strings"
 )
 
 var rcfileFlag string
@@ -19,36 +20,60 @@ func init() {
 }
 
 type Config struct {
-	Address   string
-	Root      string
-	Template  string
-	Interval  int
-	Buflines  int
-	Lastlines int
-	Lotfs     []LotfConfig
+	Address    string
+	Root       string
+	Template   string
+	Interval   int
+	Buflines   int
+	Lastlines  int
+	Maxhistory int // lines of a history request at most, buflines if 0
+	Maxopen    int
+	Reload     bool
+	Lotfs      []LotfConfig
 }
 
 type LotfConfig struct {
 	Name     string
 	File     string
+	Syslog   string
+	Command  []string
 	Filter   string
+	Mapper   string
 	Template string
+	Markers  bool
+	Rotated  bool
+	Rawlines int
+	Dedup    string
+	Before   int
+	After    int
 }
 
 type config struct {
-	addr      string
-	root      string
-	template  string
-	interval  int
-	buflines  int
-	lastlines int
-	lotfs     map[string]*lotfConfig
+	addr       string
+	root       string
+	template   string
+	interval   int
+	buflines   int
+	lastlines  int
+	maxhistory int
+	maxopen    int
+	reload     bool // reloads filter and mapper files on change
+	lotfs      map[string]*lotfConfig
 }
 
 type lotfConfig struct {
 	filename string
+	syslog   bool     // filename is syslog url
+	command  []string // filename is command line joined if not nil
 	filter   lotf.Filter
+	mapper   lotf.Mapper
 	template string
+	markers  bool
+	rotated  bool
+	rawlines int // lines kept before filtering to rebuild on reload
+	dedup    *lotf.Dedup
+	before   int // context lines around the ones filter accepts
+	after    int
 }
 
 func makeResources(fname string) (*config, error) {
@@ -68,6 +93,8 @@ func makeResources(fname string) (*config, error) {
 	}
 
 	lotfs := make(map[string]*lotfConfig)
+	mappers := make(map[string]string) // mapper spec by filename
+	dedups := make(map[string]string)  // dedup spec by filename
 	for _, v := range s.Lotfs {
 		// XXX: check required json entries
 		if len(v.Name) == 0 {
@@ -78,21 +105,84 @@ func makeResources(fname string) (*config, error) {
 		}
 		var filter lotf.Filter
 		if len(v.Filter) > 0 {
-			filter, err = lotf.RegexpFilter(v.Filter)
+			filter, err = lotf.NewFilter(v.Filter)
 			if err != nil {
 				return nil, errors.New(fmt.Sprintf("create filter: %s", v.Filter))
 			}
 		} else {
 			filter = nil
 		}
-		if len(v.File) == 0 {
+		var mapper lotf.Mapper
+		if len(v.Mapper) > 0 {
+			if mapper, err = lotf.NewMapper(v.Mapper); err != nil {
+				return nil, errors.New(fmt.Sprintf("create mapper: %s: %s", v.Mapper, err))
+			}
+		}
+		var dedup *lotf.Dedup
+		if len(v.Dedup) > 0 {
+			if dedup, err = lotf.NewDedup(v.Dedup); err != nil {
+				return nil, errors.New(fmt.Sprintf("create dedup: %s: %s", v.Dedup, err))
+			}
+		}
+		nsource := 0
+		filename := v.File
+		if len(v.File) > 0 {
+			nsource++
+		}
+		if len(v.Syslog) > 0 {
+			filename = v.Syslog
+			nsource++
+		}
+		if len(v.Command) > 0 {
+			filename = strings.Join(v.Command, " ")
+			nsource++
+		}
+		if nsource == 0 {
 			return nil, errors.New(fmt.Sprintf("no file specified: %s", v.Name))
+		} else if nsource > 1 {
+			return nil, errors.New(fmt.Sprintf("only one of file, syslog or command can be specified: %s", v.Name))
+		}
+		// a shared file is ingested once, so that the mapper and dedup must be
+		// the same
+		if spec, found := mappers[filename]; found && spec != v.Mapper {
+			return nil, errors.New(fmt.Sprintf("different mapper for the same file: %s", v.Name))
 		}
+		mappers[filename] = v.Mapper
+		if spec, found := dedups[filename]; found && spec != v.Dedup {
+			return nil, errors.New(fmt.Sprintf("different dedup for the same file: %s", v.Name))
+		}
+		dedups[filename] = v.Dedup
 
 		lotfs[v.Name] = &lotfConfig{
-			filename: v.File,
+			filename: filename,
+			syslog:   len(v.Syslog) > 0,
+			command:  v.Command,
 			filter:   filter,
+			mapper:   mapper,
 			template: v.Template,
+			markers:  v.Markers,
+			rotated:  v.Rotated,
+			rawlines: v.Rawlines,
+			dedup:    dedup,
+			before:   v.Before,
+			after:    v.After,
+		}
+	}
+	// lines of a shared file are filtered on reading, with no context, and
+	// ingested with the same options
+	shared := make(map[string][]*lotfConfig)
+	for _, v := range lotfs {
+		shared[v.filename] = append(shared[v.filename], v)
+	}
+	for name, v := range lotfs {
+		if len(shared[v.filename]) == 1 {
+			continue
+		}
+		if v.before > 0 || v.after > 0 {
+			return nil, errors.New(fmt.Sprintf("context lines for the shared file: %s", name))
+		}
+		if first := shared[v.filename][0]; first.markers != v.markers || first.rotated != v.rotated || first.rawlines != v.rawlines {
+			return nil, errors.New(fmt.Sprintf("different markers, rotated or rawlines for the same file: %s", name))
 		}
 	}
 
@@ -114,6 +204,11 @@ func makeResources(fname string) (*config, error) {
 	if s.Lastlines == 0 {
 		return nil, errors.New("lastlines is not specified")
 	}
+	if s.Maxhistory == 0 {
+		s.Maxhistory = s.Buflines
+	} else if s.Maxhistory < 0 {
+		return nil, errors.New("negative maxhistory")
+	}
 	if len(lotfs) == 0 {
 		return nil, errors.New("no lotf specified")
 	}
@@ -122,13 +217,16 @@ func makeResources(fname string) (*config, error) {
 		s.Root += "/"
 	}
 	return &config{
-		addr:      s.Address,
-		root:      s.Root,
-		template:  s.Template,
-		interval:  s.Interval,
-		buflines:  s.Buflines,
-		lastlines: s.Lastlines,
-		lotfs:     lotfs,
+		addr:       s.Address,
+		root:       s.Root,
+		template:   s.Template,
+		interval:   s.Interval,
+		buflines:   s.Buflines,
+		lastlines:  s.Lastlines,
+		maxhistory: s.Maxhistory,
+		maxopen:    s.Maxopen,
+		reload:     s.Reload,
+		lotfs:      lotfs,
 	}, nil
 }
 
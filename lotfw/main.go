@@ -9,27 +9,41 @@ import (
 	"html/template"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type TemplateRC struct {
-	Title    string
-	JsonPath string
-	Expire   int
+	Title       string
+	JsonPath    string
+	HistoryPath string
+	Expire      int
+}
+
+type JsonLine struct {
+	Text   string
+	Offset int64
+	Marker string      `json:",omitempty"` // event type or "reload" if this is a marker
+	Match  *lotf.Match `json:",omitempty"` // the rule and byte spans the filter matched
 }
 
 type JsonRC struct {
-	Lines []string
+	Lines []JsonLine
 	Error string
 }
 
 const (
-	NEXT_SUFFIX = "/nextlines"
-	COOKIE_NAME = "lotf"
+	NEXT_SUFFIX    = "/nextlines"
+	HISTORY_SUFFIX = "/history"
+	PAUSE_SUFFIX   = "/pause"
+	RESUME_SUFFIX  = "/resume"
+	STATS_SUFFIX   = "/stats"
+	COOKIE_NAME    = "lotf"
 )
 
 var cfg *config
+var watcher *lotf.TailWatcher
 var cookies *TickMap
 var templates = make(map[string]*template.Template)
 var tails = make(map[string]lotf.Tail)
@@ -37,23 +51,78 @@ var tails = make(map[string]lotf.Tail)
 func makeJsonRC(t lotf.Tail) *JsonRC {
 	l := list.New()
 	for {
-		if s := t.Next(); s == nil {
+		if s := t.NextLine(); s == nil {
 			break
 		} else {
 			l.PushBack(s)
 		}
 	}
 
-	lines := make([]string, l.Len())
+	lines := make([]JsonLine, l.Len())
 	i := 0
 	for e := l.Front(); e != nil; e = e.Next() {
-		lines[i] = *(e.Value.(*string))
+		lines[i] = makeJsonLine(e.Value.(*lotf.Line))
 		i++
 	}
 	m := &JsonRC{Lines: lines, Error: ""}
 	return m
 }
 
+func makeJsonLine(line *lotf.Line) JsonLine {
+	if line.IsMarker() {
+		marker := line.Marker.Type.String()
+		if ev := line.Marker.Event; ev != nil {
+			marker = ev.Type.String()
+		}
+		return JsonLine{Text: line.Marker.String(), Marker: marker}
+	}
+	return JsonLine{Text: line.Text, Offset: line.Offset, Match: line.Match}
+}
+
+func writeJsonError(w http.ResponseWriter, err error) {
+	js, _ := json.Marshal(&JsonRC{Lines: []JsonLine{}, Error: err.Error()})
+	w.Write(js)
+}
+
+// returns lines older than the buffered, read from the file on disk
+func handleHistory(w http.ResponseWriter, r *http.Request, tail lotf.Tail, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	before, err := strconv.ParseInt(r.FormValue("before"), 0, 64)
+	if err != nil {
+		writeJsonError(w, err)
+		return
+	}
+	n := cfg.lastlines
+	if len(r.FormValue("n")) > 0 {
+		if n, err = strconv.Atoi(r.FormValue("n")); err != nil {
+			writeJsonError(w, err)
+			return
+		}
+	}
+	if n < 1 {
+		writeJsonError(w, fmt.Errorf("invalid n: %d", n))
+		return
+	} else if n > cfg.maxhistory {
+		n = cfg.maxhistory
+	}
+
+	history, err := tail.History(before, n, cfg.lotfs[name].filter)
+	if err != nil {
+		writeJsonError(w, err)
+		return
+	}
+	lines := make([]JsonLine, len(history))
+	for i, line := range history {
+		lines[i] = makeJsonLine(line)
+	}
+	js, err := json.Marshal(&JsonRC{Lines: lines, Error: ""})
+	if err != nil {
+		writeJsonError(w, err)
+		return
+	}
+	w.Write(js)
+}
+
 func handleNext(w http.ResponseWriter, r *http.Request, tail lotf.Tail) {
 	w.Header().Set("Content-Type", "application/json")
 	cookie, err := r.Cookie(COOKIE_NAME)
@@ -80,6 +149,32 @@ func handleNext(w http.ResponseWriter, r *http.Request, tail lotf.Tail) {
 	w.Write(js)
 }
 
+// pauses or resumes ingesting the file of lotf name, requires POST
+func handleControl(w http.ResponseWriter, r *http.Request, name string, op func(string) error) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := op(cfg.lotfs[name].filename); err != nil {
+		writeJsonError(w, err)
+		return
+	}
+	js, _ := json.Marshal(&JsonRC{Lines: []JsonLine{}, Error: ""})
+	w.Write(js)
+}
+
+// writes the stats of the filter, null if it has none
+func handleStats(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	js, err := json.Marshal(lotf.FilterStatsOf(cfg.lotfs[name].filter))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(js)
+}
+
 func handleFirst(w http.ResponseWriter, r *http.Request, tail lotf.Tail, name string) {
 	uuid, err := cookies.Add(tail.Clone())
 	if err != nil {
@@ -92,9 +187,10 @@ func handleFirst(w http.ResponseWriter, r *http.Request, tail lotf.Tail, name st
 		Path:  cfg.root,
 	})
 	rc := &TemplateRC{
-		Title:    fmt.Sprintf("%s", tail),
-		JsonPath: r.URL.Path + NEXT_SUFFIX,
-		Expire:   cfg.interval * 1000 / 2,
+		Title:       fmt.Sprintf("%s", tail),
+		JsonPath:    r.URL.Path + NEXT_SUFFIX,
+		HistoryPath: r.URL.Path + HISTORY_SUFFIX,
+		Expire:      cfg.interval * 1000 / 2,
 	}
 	if err := templates[name].ExecuteTemplate(w, cfg.lotfs[name].template, rc); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -112,6 +208,34 @@ func handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		handleNext(w, r, tail)
+	} else if strings.HasSuffix(rpath, HISTORY_SUFFIX) {
+		key := rpath[:len(rpath)-len(HISTORY_SUFFIX)]
+		if tail, found = tails[key]; !found {
+			http.NotFound(w, r)
+			return
+		}
+		handleHistory(w, r, tail, key)
+	} else if strings.HasSuffix(rpath, PAUSE_SUFFIX) {
+		key := rpath[:len(rpath)-len(PAUSE_SUFFIX)]
+		if _, found = tails[key]; !found {
+			http.NotFound(w, r)
+			return
+		}
+		handleControl(w, r, key, watcher.Pause)
+	} else if strings.HasSuffix(rpath, RESUME_SUFFIX) {
+		key := rpath[:len(rpath)-len(RESUME_SUFFIX)]
+		if _, found = tails[key]; !found {
+			http.NotFound(w, r)
+			return
+		}
+		handleControl(w, r, key, watcher.Resume)
+	} else if strings.HasSuffix(rpath, STATS_SUFFIX) {
+		key := rpath[:len(rpath)-len(STATS_SUFFIX)]
+		if _, found = tails[key]; !found {
+			http.NotFound(w, r)
+			return
+		}
+		handleStats(w, r, key)
 	} else {
 		if tail, found = tails[rpath]; !found {
 			http.NotFound(w, r)
@@ -121,6 +245,31 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// adds the file, syslog receiver or command of v to watcher, or returns a view
+// on it if already added
+func addTail(watcher *lotf.TailWatcher, v *lotfConfig, filter lotf.Filter) (lotf.Tail, error) {
+	opts := &lotf.TailOptions{Markers: v.markers, RotatedHistory: v.rotated, Mapper: v.mapper, RawLines: v.rawlines, Dedup: v.dedup,
+		Before: v.before, After: v.after}
+	if !v.syslog && v.command == nil {
+		return watcher.AddOptions(v.filename, cfg.buflines, filter, cfg.lastlines, opts)
+	}
+	if t, err := watcher.Lookup(v.filename); err == nil {
+		t.SetView(filter)
+		return t, nil
+	}
+	var src lotf.Source
+	var err error
+	if v.syslog {
+		src, err = lotf.NewSyslogSource(v.filename)
+	} else {
+		src, err = lotf.NewCommandSource(v.command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return watcher.AddSourceOptions(src, cfg.buflines, filter, opts)
+}
+
 func main() {
 	var err error
 
@@ -130,12 +279,16 @@ func main() {
 	}
 
 	cookies = NewTickMap(time.Duration(cfg.interval) * time.Second)
-	watcher, err := lotf.NewTailWatcher()
+	watcher, err = lotf.NewTailWatcher()
 	if err != nil {
 		glog.Fatalf("NewTailWatcher: %s", err)
 	}
+	watcher.SetMaxOpen(cfg.maxopen)
 	go func() {
 		for err := range watcher.Error {
+			if lotf.IsFatal(err) {
+				glog.Fatalf("fatal error from watcher: %s", err)
+			}
 			glog.Errorf("error from watcher: %s", err)
 		}
 	}()
@@ -143,12 +296,35 @@ func main() {
 	templateNames := make(map[string]*template.Template)
 	defaultTemplate := template.Must(template.ParseFiles(cfg.template))
 	templates[defaultTemplate.Name()] = defaultTemplate
+	// a file shared by several lotfs is ingested once with no filter, then
+	// each of them gets its own view
+	shared := make(map[string]int)
+	for _, v := range cfg.lotfs {
+		shared[v.filename]++
+	}
 	for k, v := range cfg.lotfs {
 		glog.Infof("creating tail: %s", v.filename)
-		t, err := watcher.Add(v.filename, cfg.buflines, v.filter, cfg.lastlines)
+		if shared[v.filename] > 1 {
+			if _, err := watcher.Lookup(v.filename); err != nil {
+				if _, err = addTail(watcher, v, nil); err != nil {
+					glog.Fatalf("Add to watcher - %s: %s", v.filename, err)
+				}
+			}
+		}
+		t, err := addTail(watcher, v, v.filter)
 		if err != nil {
 			glog.Fatalf("Add to watcher - %s: %s", v.filename, err)
 		}
+		if cfg.reload {
+			for _, r := range []lotf.Reloader{v.filter, v.mapper} {
+				if r == nil {
+					continue
+				}
+				if err := watcher.WatchReload(r); err != nil {
+					glog.Fatalf("watch reload - %s: %s", v.filename, err)
+				}
+			}
+		}
 		if len(v.template) == 0 {
 			templates[k] = defaultTemplate
 		} else {
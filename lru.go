@@ -0,0 +1,130 @@
+package lotf
+
+import (
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SetMaxOpen caps the number of files opened by tails which follow name, 0
+// means no limit which is the default. Over the cap, the least recently
+// modified ones are closed keeping lastp, device and inode, and reopened on the
+// next IN_MODIFY, or where it was renamed to in the same directory to read the
+// rest. A tail added over the cap starts closed.
+func (tw *TailWatcher) SetMaxOpen(n int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.maxopen = n
+}
+
+// removes tail from recently used list, caller must hold tw.mu
+func (tw *TailWatcher) untrack(tail *TailName) {
+	if tail.lru != nil {
+		tw.lru.Remove(tail.lru)
+		tail.lru = nil
+	}
+}
+
+// updates recently used list after an event of tail, and closes the least
+// recently used ones over the cap. This is called only from follow so that
+// no handler is using the files to close.
+func (tw *TailWatcher) track(tail *TailName) {
+	victims := make([]*TailName, 0)
+
+	tw.mu.Lock()
+	if tw.closed || tw.tails[tail.name] != tail {
+		tw.mu.Unlock()
+		return
+	}
+	if tail.file == nil {
+		tw.untrack(tail)
+	} else if tail.lru == nil {
+		tail.lru = tw.lru.PushFront(tail)
+	} else {
+		tw.lru.MoveToFront(tail.lru)
+	}
+	for tw.maxopen > 0 && tw.lru.Len() > tw.maxopen {
+		victim := tw.lru.Remove(tw.lru.Back()).(*TailName)
+		victim.lru = nil
+		victims = append(victims, victim)
+	}
+	tw.mu.Unlock()
+
+	for _, victim := range victims {
+		victim.closeIdle()
+	}
+}
+
+// closes the file keeping lastp and identity to reopen
+func (tail *TailName) closeIdle() {
+	if tail.file == nil {
+		return
+	}
+	if err := tail.file.Close(); err != nil && glog.V(1) {
+		glog.Infof("File.Close(): %s", err)
+	}
+	tail.file = nil
+	tail.idle = true
+}
+
+// opens the file closed by closeIdle if it was renamed in the same directory,
+// looking up the device and inode. Returns nil if not found.
+func (tail *TailName) openMoved() *os.File {
+	dir := filepath.Dir(tail.name)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		glog.Infof("ReadDir(%s): %s", dir, err)
+		return nil
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || fileIno(fi) != tail.ino || fileDev(fi) != tail.dev {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			glog.Infof("File.Open(%s): %s", fi.Name(), err)
+			return nil
+		}
+		// may be renamed again after ReadDir
+		if fi, err = file.Stat(); err != nil || fileIno(fi) != tail.ino || fileDev(fi) != tail.dev {
+			file.Close()
+			return nil
+		}
+		return file
+	}
+	return nil
+}
+
+// reopens the file closed by closeIdle. If the name is not the same file,
+// device and inode, any more, the old one is opened where it was renamed to so
+// that following IN_MOVED_FROM or IN_MOVED_TO drains it, or it is regarded as
+// created.
+func (tail *TailName) reopen(errch chan<- error) {
+	tail.idle = false
+	file, err := os.Open(tail.name)
+	if err != nil {
+		if tail.file = tail.openMoved(); tail.file != nil {
+			return
+		}
+		glog.Infof("File.Open(%s): %s", tail.name, err)
+		tail.sendError(errch, "open", SEVERITY_ERROR, err)
+		return
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		glog.Infof("File.Stat(): %s", err)
+		tail.sendError(errch, "stat", SEVERITY_ERROR, err)
+		return
+	}
+	if fileIno(fi) != tail.ino || fileDev(fi) != tail.dev {
+		file.Close()
+		if tail.file = tail.openMoved(); tail.file != nil {
+			return
+		}
+		tail.handleCreate(errch)
+		return
+	}
+	tail.file = file
+}
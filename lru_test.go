@@ -0,0 +1,121 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaxOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+	tw.SetMaxOpen(2)
+
+	names := make([]string, 3)
+	tails := make([]*TailName, 3)
+	for i := range names {
+		names[i] = filepath.Join(dir, fmt.Sprintf("TailWatcher.testfile%d", i))
+		if err := ioutil.WriteFile(names[i], nil, 0666); err != nil {
+			t.Fatalf("failed to write testFile: %s", err)
+		}
+	}
+	for i := range names {
+		tail, err := tw.Add(names[i], 8, nil, 0)
+		if err != nil {
+			t.Fatalf("failed to Add to TailWatcher: %s", err)
+		}
+		tails[i] = tail.(*TailName)
+	}
+	opened := func() string {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		s := ""
+		for e := tw.lru.Front(); e != nil; e = e.Next() {
+			s += filepath.Base(e.Value.(*TailName).name)[len("TailWatcher.testfile"):]
+		}
+		return s
+	}
+	// track runs after lines are ingested
+	waitOpened := func(want string) {
+		for i := 0; opened() != want; i++ {
+			if i > 100 {
+				t.Fatalf("expect %s opened, but got: %s", want, opened())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	write := func(i int, s string) {
+		f, err := os.OpenFile(names[i], os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			t.Fatalf("failed to open testFile: %s", err)
+		}
+		f.WriteString(s)
+		f.Close()
+	}
+	waitOpened("10")
+
+	// resumes 2 and closes 0
+	write(2, "a\n")
+	if line := tails[2].WaitNextLine(); line == nil || line.Text != "a" {
+		t.Fatalf("expect a, but got: %v", line)
+	}
+	write(0, "b\n")
+	if line := tails[0].WaitNextLine(); line == nil || line.Text != "b" {
+		t.Fatalf("expect b, but got: %v", line)
+	}
+	write(2, "c\n")
+	if line := tails[2].WaitNextLine(); line == nil || line.Text != "c" || line.Offset != 2 {
+		t.Fatalf("expect c@2, but got: %v", line)
+	}
+	waitOpened("20")
+
+	// idle 1 is replaced, then created one is read from the head
+	if err := os.Rename(names[2], names[1]); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if line := tails[1].WaitNextLine(); line == nil || line.Text != "a" {
+		t.Fatalf("expect a, but got: %v", line)
+	}
+	if line := tails[1].WaitNextLine(); line == nil || line.Text != "c" {
+		t.Fatalf("expect c, but got: %v", line)
+	}
+	waitOpened("10")
+
+	// 0 is closed leaving unfinished x, which is read after renamed
+	write(0, "d\nx")
+	if line := tails[0].WaitNextLine(); line == nil || line.Text != "d" {
+		t.Fatalf("expect d, but got: %v", line)
+	}
+	if err := ioutil.WriteFile(names[2], nil, 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	write(1, "e\n")
+	if line := tails[1].WaitNextLine(); line == nil || line.Text != "e" {
+		t.Fatalf("expect e, but got: %v", line)
+	}
+	waitOpened("12")
+	if err := os.Rename(names[0], names[0]+".1"); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if line := tails[0].WaitNextLine(); line == nil || line.Text != "x" || line.Offset != 4 {
+		t.Fatalf("expect x@4, but got: %v", line)
+	}
+}
@@ -0,0 +1,374 @@
+package lotf
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mapper rewrites a line which Filter accepted before it is stored, e.g. to
+// mask secrets. Reload is called on the same occasion as Filter.Reload.
+type Mapper interface {
+	Map(string) string
+	Reload() error
+}
+
+// MapperFactory creates Mapper from the part of a spec after "scheme:".
+type MapperFactory func(arg string) (Mapper, error)
+
+var mapperSchemes = struct {
+	sync.RWMutex
+	m map[string]MapperFactory
+}{m: make(map[string]MapperFactory)}
+
+func init() {
+	RegisterMapper("subst", SubstMapper)
+	RegisterMapper("redact", RedactMapper)
+}
+
+// RegisterMapper makes specs "scheme:arg" resolved by factory in NewMapper.
+func RegisterMapper(scheme string, factory MapperFactory) {
+	mapperSchemes.Lock()
+	defer mapperSchemes.Unlock()
+	mapperSchemes.m[scheme] = factory
+}
+
+// NewMapper creates Mapper from spec, which is "scheme:arg" terms chained by
+// " | " and applied from left. Built-in schemes are:
+//
+//	subst:<file>     s/regexp/replacement/flags lines in the file
+//	redact:<name>    creditcard, bearer or email
+func NewMapper(spec string) (Mapper, error) {
+	var mappers []Mapper
+	for _, term := range strings.Split(spec, " | ") {
+		term = strings.TrimSpace(term)
+		i := strings.IndexByte(term, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("no scheme in mapper spec: %s", term)
+		}
+		mapperSchemes.RLock()
+		factory, found := mapperSchemes.m[term[:i]]
+		mapperSchemes.RUnlock()
+		if !found {
+			return nil, fmt.Errorf("unknown mapper scheme: %s", term[:i])
+		}
+		m, err := factory(term[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		mappers = append(mappers, m)
+	}
+	return Chain(mappers...), nil
+}
+
+type chainMapper []Mapper
+
+// Chain returns Mapper which applies mappers in order. Reload reloads all of
+// them and returns the first error.
+func Chain(mappers ...Mapper) Mapper {
+	if len(mappers) == 1 {
+		return mappers[0]
+	}
+	return chainMapper(mappers)
+}
+
+func (c chainMapper) Map(s string) string {
+	for _, m := range c {
+		s = m.Map(s)
+	}
+	return s
+}
+
+func (c chainMapper) Reload() error {
+	var first error
+	for _, m := range c {
+		if err := m.Reload(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (c chainMapper) Sources() []string {
+	var files []string
+	for _, m := range c {
+		files = appendSources(files, m)
+	}
+	return files
+}
+
+func (c chainMapper) String() string {
+	s := make([]string, len(c))
+	for i, m := range c {
+		s[i] = fmt.Sprint(m)
+	}
+	return strings.Join(s, " | ")
+}
+
+// maps stored lines except markers
+func mapLines(q *Blockq, mapper Mapper) {
+	if mapper == nil {
+		return
+	}
+	for e := q.Head(); e != nil; e = e.Next() {
+		if line := e.Value.(*Line); !line.IsMarker() {
+			line.Text, line.Match = mapLine(mapper, line)
+		}
+	}
+}
+
+// a substitution of s command
+type subst struct {
+	re     *regexp.Regexp
+	repl   string // in the form of regexp.Expand
+	global bool
+}
+
+func (s *subst) apply(line string) string {
+	if s.global {
+		return s.re.ReplaceAllString(line, s.repl)
+	}
+	loc := s.re.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return line
+	}
+	return line[:loc[0]] + string(s.re.ExpandString(nil, s.repl, line, loc)) + line[loc[1]:]
+}
+
+// splits s by unescaped delim, escapes are kept
+func splitDelim(s string, delim byte) []string {
+	var fields []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == delim {
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// converts sed replacement, \1 and &, to regexp.Expand template
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] >= '0' && s[i] <= '9' {
+				b.WriteString("${" + s[i:i+1] + "}")
+			} else if s[i] == 'n' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parses s/regexp/replacement/flags, the delimiter can be any character
+func parseSubst(line string) (*subst, error) {
+	if len(line) < 2 || line[0] != 's' {
+		return nil, fmt.Errorf("not s command: %s", line)
+	}
+	delim := line[1]
+	fields := splitDelim(line[2:], delim)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unterminated s command: %s", line)
+	}
+	expr := fields[0]
+	if !strings.ContainsRune(`\.+*?()|[]{}^$`, rune(delim)) {
+		expr = strings.Replace(expr, `\`+string(delim), string(delim), -1)
+	}
+	s := &subst{repl: sedReplacement(fields[1])}
+	for _, c := range fields[2] {
+		switch c {
+		case 'g':
+			s.global = true
+		case 'i':
+			expr = "(?i)" + expr
+		default:
+			return nil, fmt.Errorf("unknown flag: %c", c)
+		}
+	}
+	var err error
+	if s.re, err = regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// substMapper is subst: scheme, see SubstMapper.
+type substMapper struct {
+	path   string
+	substs atomic.Value // []*subst, swapped by Reload
+}
+
+// SubstMapper creates Mapper from a file of sed like substitutions, one per
+// line, applied in order:
+//
+//	s/regexp/replacement/flags
+//
+// replacement can refer to submatches by \1...\9 and the whole by &. flags
+// are g to replace all, not only the first, and i for case-insensitive. Empty
+// lines and lines starting with # are ignored.
+func SubstMapper(path string) (Mapper, error) {
+	substs, err := readSubsts(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &substMapper{path: path}
+	m.substs.Store(substs)
+	return m, nil
+}
+
+func readSubsts(path string) ([]*subst, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var substs []*subst
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseSubst(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineno, err)
+		}
+		substs = append(substs, s)
+	}
+	return substs, scanner.Err()
+}
+
+func (m *substMapper) Map(line string) string {
+	for _, s := range m.substs.Load().([]*subst) {
+		line = s.apply(line)
+	}
+	return line
+}
+
+// Reload reads the file again, and keeps the current substitutions on error.
+func (m *substMapper) Reload() error {
+	substs, err := readSubsts(m.path)
+	if err != nil {
+		return err
+	}
+	m.substs.Store(substs)
+	return nil
+}
+
+func (m *substMapper) Sources() []string {
+	return []string{m.path}
+}
+
+func (m *substMapper) String() string {
+	return "subst:" + m.path
+}
+
+const REDACTED = "[REDACTED]"
+
+var (
+	cardExp   = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	bearerExp = regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	emailExp  = regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}\b`)
+)
+
+// returns true if digits of s pass Luhn check
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// masks digits except the last four, keeping separators
+func maskCard(s string) string {
+	if !luhn(s) {
+		return s
+	}
+	b := []byte(s)
+	keep := 4
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '0' || b[i] > '9' {
+			continue
+		}
+		if keep > 0 {
+			keep--
+		} else {
+			b[i] = '*'
+		}
+	}
+	return string(b)
+}
+
+type redactMapper struct {
+	name   string
+	redact func(string) string
+}
+
+// RedactMapper returns a built-in redactor registered as "redact:" scheme:
+//
+//	creditcard  masks digits of card numbers passing Luhn check except last 4
+//	bearer      replaces the token of "Bearer <token>" by [REDACTED]
+//	email       replaces email addresses by [REDACTED]
+func RedactMapper(name string) (Mapper, error) {
+	m := &redactMapper{name: name}
+	switch name {
+	case "creditcard":
+		m.redact = func(s string) string {
+			return cardExp.ReplaceAllStringFunc(s, maskCard)
+		}
+	case "bearer":
+		m.redact = func(s string) string {
+			return bearerExp.ReplaceAllString(s, "${1}"+REDACTED)
+		}
+	case "email":
+		m.redact = func(s string) string {
+			return emailExp.ReplaceAllLiteralString(s, REDACTED)
+		}
+	default:
+		return nil, fmt.Errorf("unknown redactor: %s", name)
+	}
+	return m, nil
+}
+
+func (m *redactMapper) Map(s string) string {
+	return m.redact(s)
+}
+
+func (m *redactMapper) Reload() error {
+	return nil
+}
+
+func (m *redactMapper) String() string {
+	return "redact:" + m.name
+}
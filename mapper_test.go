@@ -0,0 +1,135 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSubstMapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "subst")
+
+	for _, c := range []struct {
+		subst, line, expect string
+	}{
+		{`s/password=\S+/password=***/`, "user=a password=secret x", "user=a password=*** x"},
+		{`s/a/b/`, "aaa", "baa"},
+		{`s/a/b/g`, "aaa", "bbb"},
+		{`s/A/b/gi`, "aAa", "bbb"},
+		{`s/(\w+)@(\w+)/\2 at \1 [&] $1/`, "to joe@host", "to host at joe [joe@host] $1"},
+		{`s|/home/[^/]*|/home/\|x|`, "/home/joe/.ssh", "/home/|x/.ssh"},
+		{`s,a\,b,c,`, "a,b", "c"},
+		{"# comment\n\ns/a/b/\ns/b/c/\n", "a", "c"},
+	} {
+		if err := ioutil.WriteFile(fname, []byte(c.subst), 0666); err != nil {
+			t.Fatalf("failed to write subst: %s", err)
+		}
+		m, err := NewMapper("subst:" + fname)
+		if err != nil {
+			t.Fatalf("NewMapper(%q): %s", c.subst, err)
+		}
+		if s := m.Map(c.line); s != c.expect {
+			t.Fatalf("subst: %q, expect: %q, but got: %q", c.subst, c.expect, s)
+		}
+	}
+
+	for _, c := range []struct {
+		subst, expect string
+	}{
+		{"s/a/b/\ns/a/b", fname + ":2: unterminated"},
+		{"s/a/b/x", fname + ":1: unknown flag: x"},
+		{"\nx/a/b/", fname + ":2: not s command"},
+		{"s/(/b/", fname + ":1: error parsing regexp"},
+	} {
+		if err := ioutil.WriteFile(fname, []byte(c.subst), 0666); err != nil {
+			t.Fatalf("failed to write subst: %s", err)
+		}
+		if _, err := SubstMapper(fname); err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Fatalf("subst: %q, expect error: %s, but got: %v", c.subst, c.expect, err)
+		}
+	}
+}
+
+func TestRedactMapper(t *testing.T) {
+	m, err := NewMapper("redact:creditcard | redact:bearer | redact:email")
+	if err != nil {
+		t.Fatalf("NewMapper: %s", err)
+	}
+	for line, expect := range map[string]string{
+		"card 4111 1111 1111 1111 ok":             "card **** **** **** 1111 ok",
+		"card 4111-1111-1111-1112 not luhn":       "card 4111-1111-1111-1112 not luhn",
+		"id 4111111111111111":                     "id ************1111",
+		"Authorization: Bearer abc.DEF-123= next": "Authorization: Bearer [REDACTED] next",
+		"from joe.doe+x@mail.example.com:":        "from [REDACTED]:",
+		"nothing 1234 here":                       "nothing 1234 here",
+	} {
+		if s := m.Map(line); s != expect {
+			t.Fatalf("expect: %q, but got: %q", expect, s)
+		}
+	}
+	for _, spec := range []string{"redact:phone", "subst", "nothing:x"} {
+		if _, err := NewMapper(spec); err == nil {
+			t.Fatalf("expect error from spec: %s", spec)
+		}
+	}
+}
+
+func TestMapperTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a x@y.example\nb\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	mapper, _ := RedactMapper("email")
+	filter, _ := NewFilter("substr:@")
+	tail, err := tw.AddOptions(fname, 8, filter, 10, &TailOptions{Mapper: mapper})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	if line := tail.NextLine(); line == nil || line.Text != "a [REDACTED]" {
+		t.Fatalf("expect a [REDACTED], but got: %v", line)
+	}
+
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("c\nd z@w.example\n")
+	wfile.Close()
+	// filtered by the text before mapped
+	if line := tail.WaitNextLine(); line == nil || line.Text != "d [REDACTED]" {
+		t.Fatalf("expect d [REDACTED], but got: %v", line)
+	}
+
+	lines, err := tail.History(100, 10, nil)
+	if err != nil {
+		t.Fatalf("History: %s", err)
+	}
+	if len(lines) != 4 || lines[0].Text != "a [REDACTED]" || lines[3].Text != "d [REDACTED]" {
+		t.Fatalf("expect mapped history, but got: %v", lines)
+	}
+}
@@ -0,0 +1,105 @@
+package lotf
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Span is the byte range [Start, End) of a line which a filter matched.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Match tells why a Matcher accepted a line, see Line.Match.
+type Match struct {
+	Rule  string // the rule or pattern which matched
+	Spans []Span // matched parts of the line in order, none if it is not known
+}
+
+// Matcher is Filter which can also tell which rule matched and where. Tail
+// checks lines by Match if its filter implements this, and carries the result
+// on Line.Match.
+type Matcher interface {
+	Filter
+	// Match returns what Filter returns, and the match if it is true and
+	// known, nil otherwise.
+	Match(line string) (bool, *Match)
+}
+
+// returns m without spans, which are of the text before a mapper rewrote it
+func (m *Match) mapped() *Match {
+	if m == nil || len(m.Spans) == 0 {
+		return m
+	}
+	return &Match{Rule: m.Rule}
+}
+
+// returns the text of line rewritten by mapper, with the match of it
+func mapLine(mapper Mapper, line *Line) (string, *Match) {
+	text := mapper.Map(line.Text)
+	if text == line.Text {
+		return text, line.Match
+	}
+	return text, line.Match.mapped()
+}
+
+// filters line by filter, which may be nil, with the match if it is a Matcher
+func matchLine(filter Filter, line string) (bool, *Match) {
+	if filter == nil {
+		return true, nil
+	}
+	if m, ok := filter.(Matcher); ok {
+		return m.Match(line)
+	}
+	return filter.Filter(line), nil
+}
+
+// returns spans of all matches of re in s, adjacent ones are joined and empty
+// ones are dropped
+func regexpSpans(re *regexp.Regexp, s string) []Span {
+	var spans []Span
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		switch {
+		case loc[0] == loc[1]:
+		case len(spans) > 0 && spans[len(spans)-1].End == loc[0]:
+			spans[len(spans)-1].End = loc[1]
+		default:
+			spans = append(spans, Span{loc[0], loc[1]})
+		}
+	}
+	return spans
+}
+
+// returns spans of all substr in s, folded to lower case if fold. Spans are
+// not known if folding changes the length of s.
+func substrSpans(s, substr string, fold bool) []Span {
+	if fold {
+		if lower := strings.ToLower(s); len(lower) == len(s) {
+			s = lower
+		} else {
+			return nil
+		}
+	}
+	var spans []Span
+	for i := 0; len(substr) > 0; {
+		j := strings.Index(s[i:], substr)
+		if j < 0 {
+			break
+		}
+		spans = append(spans, Span{i + j, i + j + len(substr)})
+		i += j + len(substr)
+	}
+	return spans
+}
+
+// returns spans of a and b sorted by Start
+func mergeSpans(a, b []Span) []Span {
+	if len(a) == 0 {
+		return b
+	}
+	spans := append(append([]Span{}, a...), b...)
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans
+}
@@ -0,0 +1,96 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"filter":   "^a+\nb\n",
+		"literals": "dead\nbeef\nxyz\n",
+		"rules":    "mode all\n+i ^get\n-l health\n+l /x\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+
+	for _, c := range []struct {
+		spec   string
+		line   string
+		expect string
+	}{
+		{"substr:ab", "xabyab", "ab [{1 3} {4 6}]"},
+		{"regexp:[0-9]+", "a12b3", "[0-9]+ [{1 3} {4 5}]"},
+		{"regexp:.", "abc", ". [{0 3}]"},
+		{"%s/filter", "aaxb", "^a+ [{0 2} {3 4}]"},
+		{"%s/filter", "xb", "b [{1 2}]"},
+		{"literal:%s/literals", "deadbeef xyz", "deadbeef [{0 8} {9 12}]"},
+		{"rules:%s/rules", "GET /x", "+i ^get && +l /x [{0 3} {4 6}]"},
+		{"substr:a && regexp:c", "abc", "a && c [{0 1} {2 3}]"},
+		{"substr:z || substr:b", "abc", "b [{1 2}]"},
+		{"!substr:z", "abc", "<nil>"},
+	} {
+		spec := strings.Replace(c.spec, "%s", dir, -1)
+		f, err := NewFilter(spec)
+		if err != nil {
+			t.Fatalf("NewFilter(%s): %s", spec, err)
+		}
+		pass, m := matchLine(f, c.line)
+		if !pass {
+			t.Fatalf("spec: %s, expect %s to pass", c.spec, c.line)
+		}
+		got := "<nil>"
+		if m != nil {
+			got = fmt.Sprintf("%s %v", m.Rule, m.Spans)
+		}
+		if got != c.expect {
+			t.Fatalf("spec: %s, line: %s, expect: %s, but got: %s", c.spec, c.line, c.expect, got)
+		}
+	}
+
+	f, _ := NewFilter("rules:" + filepath.Join(dir, "rules"))
+	if pass, m := matchLine(f, "GET /x/health"); pass || m != nil {
+		t.Fatalf("expect no match, but got: %v, %v", pass, m)
+	}
+}
+
+func TestMatchTail(t *testing.T) {
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	// spans are dropped from the line rewritten by mapper
+	mapper, _ := NewMapper("redact:email")
+	tail, err := tw.AddSourceOptions(&readerSource{name: "stream", r: strings.NewReader("a x\nb\nx a@b.example\n")},
+		8, substrFilter("x"), &TailOptions{Mapper: mapper})
+	if err != nil {
+		t.Fatalf("AddSourceOptions: %s", err)
+	}
+	for _, s := range []string{"a x: x [{2 3}]", "x [REDACTED]: x []"} {
+		line := tail.WaitNextLine()
+		if line == nil || line.Match == nil {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+		if got := fmt.Sprintf("%s: %s %v", line.Text, line.Match.Rule, line.Match.Spans); got != s {
+			t.Fatalf("expect %s, but got: %s", s, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package lotf
+
+import (
+	inotify "github.com/chamaken/inotify"
+	"github.com/golang/glog"
+	"os"
+)
+
+// returns TailName watched by name, streams have no event to pause
+func (tw *TailWatcher) findName(pathname, op string) (*TailName, error) {
+	tail, err := tw.find(pathname)
+	if err != nil {
+		return nil, err
+	}
+	if tail.stream != nil {
+		return nil, &TailError{Path: pathname, Op: op, Severity: SEVERITY_ERROR, Err: ErrorNotWatching}
+	}
+	return tail, nil
+}
+
+// Pause stops ingesting pathname keeping the watch. inotify events are recorded
+// and applied on Resume.
+func (tw *TailWatcher) Pause(pathname string) error {
+	tail, err := tw.findName(pathname, "pause")
+	if err != nil {
+		return err
+	}
+	tw.mu.Lock()
+	tail.paused = true
+	tw.mu.Unlock()
+	return nil
+}
+
+// Resume restarts ingesting pathname paused by Pause. Lines are read from
+// where it was paused, after rotation or truncation while paused is handled.
+// This is done asynchronously by the event dispatcher.
+func (tw *TailWatcher) Resume(pathname string) error {
+	tail, err := tw.findName(pathname, "resume")
+	if err != nil {
+		return err
+	}
+	tw.mu.Lock()
+	if tail.paused {
+		tw.resumed = append(tw.resumed, tail)
+	}
+	tw.mu.Unlock()
+	select {
+	case tw.wake <- true:
+	default: // follow has been woken up already
+	}
+	return nil
+}
+
+// records mask and returns true if tail is paused
+func (tw *TailWatcher) record(tail *TailName, mask uint32) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tail.paused {
+		tail.pending |= mask
+	}
+	return tail.paused
+}
+
+// applies events recorded while paused for resumed tails, called from follow
+func (tw *TailWatcher) catchup() {
+	tw.mu.Lock()
+	resumed := tw.resumed
+	tw.resumed = nil
+	tw.mu.Unlock()
+
+	for _, tail := range resumed {
+		tw.mu.Lock()
+		pending := tail.pending
+		tail.paused, tail.pending = false, 0
+		removed := tw.tails[tail.name] != tail
+		tw.mu.Unlock()
+		if removed || pending == 0 {
+			continue
+		}
+		if glog.V(1) {
+			glog.Infof("resuming %s, events: %#x", tail.name, pending)
+		}
+		if tail.follow == FOLLOW_DESCRIPTOR {
+			for _, mask := range []uint32{inotify.IN_MODIFY, inotify.IN_ATTRIB, inotify.IN_DELETE_SELF} {
+				if pending&mask != 0 {
+					tw.handleDescriptor(tail, mask)
+				}
+			}
+			continue
+		}
+		tw.reconcile(tail)
+		tw.track(tail)
+	}
+}
+
+// compares the file and the name instead of replaying events in order, which
+// were merged into a mask.
+func (tw *TailWatcher) reconcile(tail *TailName) {
+	fi, err := os.Stat(tail.name)
+	if err != nil && !os.IsNotExist(err) {
+		glog.Infof("Stat(%s): %s", tail.name, err)
+		tail.sendError(tw.errch, "stat", SEVERITY_ERROR, err)
+		return
+	}
+	switch {
+	case err != nil: // removed
+		tail.handleDisappear(tw.errch)
+	case tail.file == nil && !tail.idle: // created
+		tail.handleCreate(tw.errch)
+	case fileIno(fi) != tail.ino || fileDev(fi) != tail.dev: // rotated
+		tail.handleDisappear(tw.errch)
+		tail.handleCreate(tw.errch)
+	default:
+		if tail.idle {
+			tail.reopen(tw.errch)
+		}
+		tail.handleModify(tw.errch)
+	}
+}
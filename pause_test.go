@@ -0,0 +1,85 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.Add(fname, 8, nil, 1)
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	if line := tail.NextLine(); line == nil || line.Text != "a" {
+		t.Fatalf("expect a, but got: %v", line)
+	}
+	if err := tw.Pause(fname + ".none"); err == nil {
+		t.Fatalf("expect error pausing not watching file")
+	}
+	if err := tw.Pause(fname); err != nil {
+		t.Fatalf("failed to Pause: %s", err)
+	}
+
+	// append, then rotate while paused
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("b\n")
+	wfile.Close()
+	if err := os.Rename(fname, fname+".1"); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("c\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if line := tail.NextLine(); line != nil {
+		t.Fatalf("expect no line while paused, but got: %s", line)
+	}
+
+	if err := tw.Resume(fname); err != nil {
+		t.Fatalf("failed to Resume: %s", err)
+	}
+	for _, s := range []string{"b", "c"} {
+		if line := tail.WaitNextLine(); line == nil || line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+
+	// ingested as usual after resume
+	wfile, err = os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("d\n")
+	wfile.Close()
+	if line := tail.WaitNextLine(); line == nil || line.Text != "d" {
+		t.Fatalf("expect d, but got: %v", line)
+	}
+}
@@ -0,0 +1,117 @@
+package lotf
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rawLines keeps lines before filtering and markers, shared by clones.
+type rawLines struct {
+	mu    sync.Mutex // serializes storing lines and Refilter
+	lines *Blockq
+}
+
+// creates rawLines of size filled with lines from start to the last NL, or the
+// last ones if start is negative
+func fillRaw(file *os.File, size int, start int64) (*rawLines, error) {
+	q, err := NewBlockq(size)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		_, err = lastLines(file, size, q, nil)
+	} else {
+		_, err = fillLines(file, start, q, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rawLines{lines: q}, nil
+}
+
+// Refilter stores MARKER_RELOAD marker, and then the kept raw lines which the
+// current filter accepts, mapped by the current mapper. Readers can replace
+// lines before the marker with the ones after it, e.g. after Filter.Reload.
+// Repeats are collapsed from scratch by the options of TailOptions.Dedup. This
+// requires TailOptions.RawLines, ErrorNoRawLines is returned otherwise.
+func (tail *TailName) Refilter() error {
+	if tail.raw == nil {
+		return ErrorNoRawLines
+	}
+	tail.raw.mu.Lock()
+	defer tail.raw.mu.Unlock()
+
+	var dedup *Dedup
+	if tail.dedup != nil {
+		// the summary of the current run is not stored during rebuild
+		tail.dedup.mu.Lock()
+		defer tail.dedup.mu.Unlock()
+		dedup = tail.dedup.fresh()
+	}
+	var context *contextLines
+	if tail.context != nil {
+		context = tail.context.fresh()
+	}
+	tail.lines.Add(&Line{Marker: &Marker{Type: MARKER_RELOAD}})
+	for e := tail.raw.lines.Head(); e != nil; e = e.Next() {
+		line := e.Value.(*Line)
+		if line.IsMarker() {
+			if dedup != nil {
+				if summary := dedup.flush(); len(summary) > 0 {
+					tail.lines.Add(&Line{Text: summary, Offset: line.Marker.Event.OldOffset})
+				}
+			}
+			if context != nil {
+				context.skip(1)
+			}
+			tail.lines.Add(line)
+			continue
+		}
+		match, m := evalMatch(tail.filter, line.Text, STATS_NONE)
+		line = &Line{Text: line.Text, Offset: line.Offset, Match: m}
+		if context != nil {
+			context.feed(tail.lines, dedup, tail.mapper, line, match)
+		} else if match {
+			storeLine(tail.lines, dedup, tail.mapper, line)
+		}
+	}
+	if context != nil {
+		*tail.context = *context
+	}
+	if dedup != nil {
+		tail.dedup.takeOver(dedup, tail.lines)
+	}
+	return nil
+}
+
+// returns true if filter or mapper of tail is read from the file absname
+func (tail *TailName) readsFrom(absname string) bool {
+	for _, name := range appendSources(appendSources(nil, tail.filter), tail.mapper) {
+		if s, err := filepath.Abs(name); err == nil && s == absname {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuilds lines of tails keeping raw lines, which read the file absname
+func (tw *TailWatcher) refilter(absname string) {
+	var tails []*TailName
+	tw.mu.Lock()
+	for _, tail := range tw.tails {
+		if tail != nil && tail.raw != nil && tail.readsFrom(absname) {
+			tails = append(tails, tail)
+		}
+	}
+	for _, tail := range tw.streams {
+		if tail.raw != nil && tail.readsFrom(absname) {
+			tails = append(tails, tail)
+		}
+	}
+	tw.mu.Unlock()
+
+	for _, tail := range tails {
+		tail.Refilter()
+	}
+}
@@ -0,0 +1,102 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRefilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a1\nb1\na2\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	filtername := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(filtername, []byte("^a\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	filter, err := NewFilter(filtername)
+	if err != nil {
+		t.Fatalf("NewFilter: %s", err)
+	}
+	mapper, _ := NewMapper("redact:email")
+	tail, err := tw.AddOptions(fname, 8, filter, 10, &TailOptions{RawLines: 2, Mapper: mapper})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	if err := tw.WatchReload(filter); err != nil {
+		t.Fatalf("WatchReload: %s", err)
+	}
+	for _, s := range []string{"a1", "a2"} {
+		if line := tail.NextLine(); line == nil || line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+
+	// rebuilt from the last 2 raw lines
+	if err := ioutil.WriteFile(filtername, []byte("^b\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	if line := tail.WaitNextLine(); line == nil || !line.IsMarker() || line.Marker.Type != MARKER_RELOAD {
+		t.Fatalf("expect reload marker, but got: %v", line)
+	}
+	if line := tail.WaitNextLine(); line == nil || line.Text != "b1" || line.Offset != 3 {
+		t.Fatalf("expect b1 at 3, but got: %v", line)
+	}
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("a3\nb2 x@y.example\n")
+	wfile.Close()
+	if line := tail.WaitNextLine(); line == nil || line.Text != "b2 [REDACTED]" {
+		t.Fatalf("expect b2 [REDACTED], but got: %v", line)
+	}
+
+	// explicitly on a stream, after SetFilter
+	stream, err := tw.AddSourceOptions(&readerSource{name: "stream", r: strings.NewReader("x1\ny1\n")},
+		8, substrFilter("x"), &TailOptions{RawLines: 4})
+	if err != nil {
+		t.Fatalf("AddSourceOptions: %s", err)
+	}
+	if line := stream.WaitNextLine(); line == nil || line.Text != "x1" {
+		t.Fatalf("expect x1, but got: %v", line)
+	}
+	stream.SetFilter(substrFilter("y"))
+	if err := stream.Refilter(); err != nil {
+		t.Fatalf("Refilter: %s", err)
+	}
+	for _, s := range []string{"filter reloaded", "y1"} {
+		if line := stream.WaitNextLine(); line == nil || line.String() != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+
+	plain, err := tw.AddReader("plain", strings.NewReader(""), 8, nil)
+	if err != nil {
+		t.Fatalf("AddReader: %s", err)
+	}
+	if err := plain.Refilter(); err != ErrorNoRawLines {
+		t.Fatalf("expect ErrorNoRawLines, but got: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package lotf
+
+import (
+	"bufio"
+	"errors"
+	"github.com/golang/glog"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// POLL_INTERVAL is the interval to check the growth of a regular file added by
+// AddFile, since inotify watches its name which may not exist.
+const POLL_INTERVAL = time.Second
+
+// Source is a producer of lines which TailWatcher hosts next to files watched
+// by name, see AddSource.
+type Source interface {
+	// Name identifies the source in TailWatcher for Lookup and Remove.
+	Name() string
+	// Run calls ingest for each line with its offset in the source, until the
+	// end of the source or Close. ingest may be called concurrently, and should
+	// not be under a lock Close takes. warn reports an error Run recovered
+	// from. This returns nil at the end, or the error which stopped it.
+	Run(ingest func(text string, offset int64), warn func(op string, err error)) error
+	// Close makes Run return.
+	Close() error
+}
+
+// consumer is Source which tells the bytes it read, which differs from the end
+// of the last line ingested if the line has no newline.
+type consumer interface {
+	consumed() int64
+}
+
+// stream is the state of TailName which is not watched by name.
+type stream struct {
+	src  Source
+	done chan bool // closed to stop reading
+	once sync.Once
+}
+
+// stops reading and closes the source
+func (s *stream) stop() {
+	s.once.Do(func() {
+		close(s.done)
+		if err := s.src.Close(); err != nil && glog.V(1) {
+			glog.Infof("Source.Close(): %s", err)
+		}
+	})
+}
+
+func (s *stream) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// registers tail as a stream and returns a view if name is already added, see
+// Add for the view
+func (tw *TailWatcher) addStream(tail *TailName, filter Filter, ingest string) (Tail, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.closed {
+		return nil, &TailError{Path: tail.name, Op: "add", Severity: SEVERITY_ERROR, Err: ErrorClosed}
+	}
+	if found, ok := tw.streams[tail.name]; ok {
+		return viewOf(found, filter, ingest)
+	}
+	tail.params, tail.refs = ingest, 1
+	tw.streams[tail.name] = tail
+	tw.wg.Add(1)
+	return nil, nil
+}
+
+// removes the stream named name and returns true if it exists, which is
+// stopped at the last one added
+func (tw *TailWatcher) removeStream(name string) bool {
+	tw.mu.Lock()
+	tail, found := tw.streams[name]
+	if found && tail.refs > 1 {
+		tail.refs--
+		tw.mu.Unlock()
+		return true
+	}
+	if found {
+		delete(tw.streams, name)
+	}
+	tw.mu.Unlock()
+	if !found {
+		return false
+	}
+	tail.lines.Done()
+	tail.stream.stop()
+	return true
+}
+
+func newStream(src Source, maxline int, filter Filter) (*TailName, error) {
+	q, err := NewBlockq(maxline)
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("NewBlockq(): %s", err)
+		}
+		return nil, err
+	}
+	return &TailName{
+		name:    src.Name(),
+		lines:   q,
+		filter:  filter,
+		hooks:   new(hookList),
+		views:   new(viewList),
+		stream:  &stream{src: src, done: make(chan bool)},
+		current: q.head,
+	}, nil
+}
+
+// AddSource starts running src and returns its Tail, which ends after Run
+// returned. If a source of the same name is already added, this returns a view
+// on it like Add, src is not run then.
+func (tw *TailWatcher) AddSource(src Source, maxline int, filter Filter) (Tail, error) {
+	return tw.AddSourceOptions(src, maxline, filter, nil)
+}
+
+// AddSourceOptions is AddSource with Markers, Mapper, RawLines, Dedup, Before
+// and After of opts, others are ignored since a source has no position to start from.
+func (tw *TailWatcher) AddSourceOptions(src Source, maxline int, filter Filter, opts *TailOptions) (Tail, error) {
+	tail, err := newStream(src, maxline, filter)
+	if err != nil {
+		return nil, err
+	}
+	ingested := &TailOptions{}
+	if opts != nil {
+		ingested = &TailOptions{Markers: opts.Markers, Mapper: opts.Mapper, RawLines: opts.RawLines,
+			Dedup: opts.Dedup, Before: opts.Before, After: opts.After}
+		tail.markers = opts.Markers
+		tail.mapper = opts.Mapper
+		tail.dedup = opts.Dedup
+		tail.context = newContextLines(opts.Before, opts.After)
+	}
+	if opts != nil && opts.RawLines > 0 {
+		q, err := NewBlockq(opts.RawLines)
+		if err != nil {
+			return nil, err
+		}
+		tail.raw = &rawLines{lines: q}
+	}
+	if view, err := tw.addStream(tail, filter, ingestKey(maxline, 0, ingested)); view != nil || err != nil {
+		return view, err
+	}
+	go tw.runSource(tail)
+	return tail, nil
+}
+
+// AddReader starts reading lines from r, which has neither seekable history
+// nor inotify events like a pipe, and returns its Tail. name is used for
+// Lookup, Remove and errors. Line Offset is the number of bytes read before
+// it. The Tail ends after r returned EOF, and r is closed on Remove if it is
+// io.Closer. If name is already added, this returns a view on it like Add.
+func (tw *TailWatcher) AddReader(name string, r io.Reader, maxline int, filter Filter) (Tail, error) {
+	return tw.AddSource(&readerSource{name: name, r: r}, maxline, filter)
+}
+
+// AddFile is the same as AddReader but takes an opened file, named by
+// file.Name(). A regular file, e.g. deleted but still opened one, stores last
+// lines like Add and is followed by polling every POLL_INTERVAL. Others like
+// stdin, FIFO or character device are read as a stream.
+func (tw *TailWatcher) AddFile(file *os.File, maxline int, filter Filter, lines int) (Tail, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("File.Stat(): %s", err)
+		}
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return tw.AddReader(file.Name(), file, maxline, filter)
+	}
+
+	tail, err := newStream(&readerSource{name: file.Name(), r: file}, maxline, filter)
+	if err != nil {
+		return nil, err
+	}
+	tail.file = file
+	tail.ino = fileIno(fi)
+	if tail.lastp, err = lastLines(file, lines, tail.lines, filter); err != nil {
+		return nil, err
+	}
+	if view, err := tw.addStream(tail, filter, ingestKey(maxline, lines, &TailOptions{})); view != nil || err != nil {
+		return view, err
+	}
+	go tw.pollFile(tail)
+	return tail, nil
+}
+
+// runs the source until its end or the stream is stopped
+func (tw *TailWatcher) runSource(tail *TailName) {
+	defer tw.wg.Done()
+
+	// lines of concurrent ingest are stored one by one. lastp is read by Clone
+	// under tw.mu, see viewOf and Lookup.
+	var mu sync.Mutex
+	ingest := func(text string, offset int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		tail.ingest(text, offset)
+		tw.mu.Lock()
+		if end := offset + int64(len(text)) + 1; end > tail.lastp {
+			tail.lastp = end
+		}
+		tw.mu.Unlock()
+	}
+	warn := func(op string, err error) {
+		if !tail.stream.stopped() {
+			tail.sendError(tw.errch, op, SEVERITY_WARNING, err)
+		}
+	}
+	err := tail.stream.src.Run(ingest, warn)
+	if c, ok := tail.stream.src.(consumer); ok {
+		tw.mu.Lock()
+		tail.lastp = c.consumed()
+		tw.mu.Unlock()
+	}
+	if tail.stream.stopped() {
+		return
+	}
+	if err != nil {
+		glog.Infof("Source.Run(): %s", err)
+		tail.sendError(tw.errch, "read", SEVERITY_ERROR, err)
+	}
+
+	tw.mu.Lock()
+	if tw.streams[tail.name] == tail {
+		delete(tw.streams, tail.name)
+	}
+	tw.mu.Unlock()
+	tail.emit(&TailEvent{Type: TAIL_DELETE, OldOffset: tail.lastp})
+	tail.lines.Done()
+}
+
+// readerSource is Source of io.Reader, see AddReader.
+type readerSource struct {
+	name   string
+	r      io.Reader
+	offset int64 // bytes read, by Run
+}
+
+func (s *readerSource) Name() string {
+	return s.name
+}
+
+func (s *readerSource) Run(ingest func(string, int64), warn func(string, error)) error {
+	r := bufio.NewReader(s.r)
+	var pending []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err == nil {
+			ingest(string(pending[:len(pending)-1]), s.offset)
+			s.offset += int64(len(pending))
+			pending = pending[:0]
+			continue
+		}
+		if errors.Is(err, syscall.EPIPE) { // overwritten records of /dev/kmsg
+			warn("read", err)
+			continue
+		}
+		// unfinished last line
+		if len(pending) > 0 {
+			ingest(string(pending), s.offset)
+			s.offset += int64(len(pending))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+}
+
+func (s *readerSource) consumed() int64 {
+	return s.offset
+}
+
+// closes the reader if it can be closed
+func (s *readerSource) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// checks the growth of regular file until the stream is stopped
+func (tw *TailWatcher) pollFile(tail *TailName) {
+	defer tw.wg.Done()
+
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tail.stream.done:
+			return
+		case <-ticker.C:
+			tail.handleModify(tw.errch)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package lotf
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestAddReader(t *testing.T) {
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	r, w := io.Pipe()
+	tail, err := tw.AddReader("pipe", r, 8, nil)
+	if err != nil {
+		t.Fatalf("AddReader failed: %s", err)
+	}
+	clone, err := tw.Lookup("pipe")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if _, err := tail.History(0, 1, nil); err != ErrorNoHistory {
+		t.Fatalf("expect ErrorNoHistory, but got: %v", err)
+	}
+
+	w.Write([]byte("a\nb"))
+	if line := tail.WaitNextLine(); line == nil || line.Text != "a" || line.Offset != 0 {
+		t.Fatalf("expect a@0, but got: %v", line)
+	}
+	w.Write([]byte("c\nd"))
+	if line := tail.WaitNextLine(); line == nil || line.Text != "bc" || line.Offset != 2 {
+		t.Fatalf("expect bc@2, but got: %v", line)
+	}
+	w.Close()
+	if line := tail.WaitNextLine(); line == nil || line.Text != "d" || line.Offset != 5 {
+		t.Fatalf("expect d@5, but got: %v", line)
+	}
+	if line := tail.WaitNextLine(); line != nil {
+		t.Fatalf("expect end of stream, but got: %s", line)
+	}
+	// d has no newline
+	if lastp := tail.(*TailName).lastp; lastp != 6 {
+		t.Fatalf("expect the end at 6, but got: %d", lastp)
+	}
+	if s := historyString([]*Line{clone.WaitNextLine(), clone.WaitNextLine(), clone.WaitNextLine()}); s != "a@0,bc@2,d@5," {
+		t.Fatalf("unexpected lines from clone: %s", s)
+	}
+	if _, err := tw.Lookup("pipe"); err == nil {
+		t.Fatalf("ended stream should be removed")
+	}
+}
+
+func TestAddFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	// deleted but opened file
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a\nb\nc\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	defer wfile.Close()
+	rfile, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	os.Remove(fname)
+
+	tail, err := tw.AddFile(rfile, 8, nil, 2)
+	if err != nil {
+		t.Fatalf("AddFile failed: %s", err)
+	}
+	if line := tail.NextLine(); line == nil || line.Text != "b" {
+		t.Fatalf("expect b, but got: %v", line)
+	}
+	if line := tail.NextLine(); line == nil || line.Text != "c" {
+		t.Fatalf("expect c, but got: %v", line)
+	}
+	wfile.WriteString("d\n")
+	if line := tail.WaitNextLine(); line == nil || line.Text != "d" || line.Offset != 6 {
+		t.Fatalf("expect d@6, but got: %v", line)
+	}
+	lines, err := tail.History(4, 10, nil)
+	if err != nil {
+		t.Fatalf("History failed: %s", err)
+	}
+	if s := historyString(lines); s != "a@0,b@2," {
+		t.Fatalf("unexpected history: %s", s)
+	}
+	if err := tw.Remove(rfile.Name()); err != nil {
+		t.Fatalf("Remove failed: %s", err)
+	}
+	if line := tail.WaitNextLine(); line != nil {
+		t.Fatalf("expect end of lines, but got: %s", line)
+	}
+
+	// FIFO
+	fifo := filepath.Join(dir, "TailWatcher.fifo")
+	if err := syscall.Mkfifo(fifo, 0666); err != nil {
+		t.Fatalf("Mkfifo failed: %s", err)
+	}
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Errorf("failed to open FIFO: %s", err)
+			return
+		}
+		w.WriteString("x\ny\n")
+		w.Close()
+	}()
+	rfifo, err := os.Open(fifo)
+	if err != nil {
+		t.Fatalf("failed to open FIFO: %s", err)
+	}
+	tail, err = tw.AddFile(rfifo, 8, nil, 2)
+	if err != nil {
+		t.Fatalf("AddFile failed: %s", err)
+	}
+	for _, s := range []string{"x", "y"} {
+		if line := tail.WaitNextLine(); line == nil || line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+	if line := tail.WaitNextLine(); line != nil {
+		t.Fatalf("expect end of stream, but got: %s", line)
+	}
+}
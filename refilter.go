@@ -8,10 +8,21 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var filternameExp *regexp.Regexp
-var filterFactory func(string, bool) (func(string) bool, error)
+var filterFactory func(string, bool) (*fileFilter, error)
+
+// fileFilter is a compiled filter file
+type fileFilter struct {
+	// returns whether line passes counting rules by mode, and the match of a
+	// passed one only if match is true and it is not inverted
+	eval   func(line string, mode statsMode, match bool) (bool, *Match)
+	rules  *fileRules
+	joined bool // rules are counted only on lines the joined regexp matches
+}
 
 type Filter interface {
 	Filter(string) bool
@@ -19,55 +30,114 @@ type Filter interface {
 }
 
 type regexpFilter struct {
-	name   string
-	invert bool
-	filter func(string) bool
+	scheme  string // prefix of String, empty for the default
+	name    string
+	invert  bool
+	factory func(string, bool) (*fileFilter, error)
+	filter  atomic.Value // *fileFilter, swapped by Reload
+	counter counter
 }
 
 func init() {
 	filternameExp = regexp.MustCompile("(!?)(.*)")
 	filterFactory = joinedExpFilter
+	RegisterFilter("perexp", PerExpFilter)
 }
 
 func RegexpFilter(filtername string) (Filter, error) {
+	return newRegexpFilter("", filtername, filterFactory)
+}
+
+// PerExpFilter creates Filter from a filter file like RegexpFilter, which
+// evaluates the regexps one by one instead of joined, and passes lines matching
+// all of them, or none of them if filtername starts with '!'. This is
+// registered as "perexp:" scheme.
+func PerExpFilter(filtername string) (Filter, error) {
+	return newRegexpFilter("perexp:", filtername, perExpFilter)
+}
+
+func newRegexpFilter(scheme, filtername string, factory func(string, bool) (*fileFilter, error)) (Filter, error) {
 	sm := filternameExp.FindStringSubmatch(filtername)
 	if sm == nil {
 		return nil, fmt.Errorf("invalid filter name: %s", filtername)
 	}
 
 	invert := len(sm[1]) > 0
-	filter, err := filterFactory(sm[2], invert)
+	filter, err := factory(sm[2], invert)
 	if err != nil {
 		return nil, err
 	}
 
-	return &regexpFilter{
-		name:   sm[2],
-		filter: filter,
-		invert: invert}, nil
+	f := &regexpFilter{scheme: scheme, name: sm[2], invert: invert, factory: factory}
+	filter.rules.counters = takeCounters(nil, nil, filter.rules.lines)
+	f.filter.Store(filter)
+	return f, nil
 }
 
 func (f *regexpFilter) Filter(line string) bool {
-	return f.filter(line)
+	return f.filterBy(line, STATS_TOP)
+}
+
+// counts the filter as a rule too, whose evaluations are the ones of the
+// joined rules
+func (f *regexpFilter) filterBy(line string, mode statsMode) bool {
+	pass, _ := f.filter.Load().(*fileFilter).eval(line, mode, false)
+	return f.counter.countRule(mode, pass, !pass)
+}
+
+// Match returns the line of the filter file which matched as the rule. An
+// inverted filter has no match.
+func (f *regexpFilter) Match(line string) (bool, *Match) {
+	return f.matchBy(line, STATS_TOP)
+}
+
+func (f *regexpFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	pass, m := f.filter.Load().(*fileFilter).eval(line, mode, true)
+	if !f.counter.countRule(mode, pass, !pass) {
+		return false, nil
+	}
+	return true, m
 }
 
 func (f *regexpFilter) Reload() error {
-	filter, err := filterFactory(f.name, f.invert)
+	filter, err := f.factory(f.name, f.invert)
 	if err != nil {
 		return err
 	}
-	f.filter = filter
+	prev := f.filter.Load().(*fileFilter).rules
+	filter.rules.counters = takeCounters(prev.lines, prev.counters, filter.rules.lines)
+	f.filter.Store(filter)
 	return nil
 }
 
+// Stats returns the lines of the filter file as rules. Rules of the joined
+// regexp, the default, are told apart only on lines it matches, so that
+// evaluations of them are the ones of the filter.
+func (f *regexpFilter) Stats() *FilterStats {
+	ff := f.filter.Load().(*fileFilter)
+	stats := f.counter.filterStats(f.String())
+	for i, line := range ff.rules.lines {
+		rs := ff.rules.counters[i].ruleStats(line)
+		if ff.joined {
+			rs.Evaluations = stats.Evaluations
+		}
+		stats.Rules = append(stats.Rules, rs)
+	}
+	return stats
+}
+
+func (f *regexpFilter) Sources() []string {
+	return []string{f.name}
+}
+
 func (f *regexpFilter) String() string {
 	if f.invert {
-		return fmt.Sprintf("!%s", f.name)
+		return fmt.Sprintf("%s!%s", f.scheme, f.name)
 	}
-	return f.name
+	return f.scheme + f.name
 }
 
-func perExpFilter(path string, inverse bool) (func(string) bool, error) {
+func perExpFilter(path string, inverse bool) (*fileFilter, error) {
 	var err error
 
 	refile, err := os.Open(path)
@@ -76,7 +146,8 @@ func perExpFilter(path string, inverse bool) (func(string) bool, error) {
 	}
 	defer refile.Close()
 
-	regexps := []*regexp.Regexp{}
+	regexps := []*literalRegexp{}
+	rules := &fileRules{}
 	r := bufio.NewReader(refile)
 
 LOOP:
@@ -101,24 +172,35 @@ LOOP:
 			line = line[:len(line)-1]
 		}
 
-		rexp, err := regexp.Compile(line)
+		rexp, err := compileLiteralRegexp(line)
 		if err != nil {
 			return nil, err
 		}
 		regexps = append(regexps, rexp)
+		rules.lines = append(rules.lines, line)
 	}
+	rules.regexps = regexps
 
-	return func(s string) bool {
-		for _, re := range regexps {
-			if (re.FindStringIndex(s) != nil) != !inverse {
-				return false
+	return &fileFilter{rules: rules, eval: func(s string, mode statsMode, match bool) (bool, *Match) {
+		for i, re := range regexps {
+			matched := re.MatchString(s)
+			if !rules.counters[i].countRule(mode, matched, matched == inverse) {
+				return false, nil
 			}
 		}
-		return true
-	}, nil
+		if !match || inverse {
+			return true, nil
+		}
+		// all of them matched
+		m := &Match{Rule: path}
+		for _, re := range regexps {
+			m.Spans = mergeSpans(m.Spans, regexpSpans(re.re, s))
+		}
+		return true, m
+	}}, nil
 }
 
-func joinedExpFilter(path string, inverse bool) (func(string) bool, error) {
+func joinedExpFilter(path string, inverse bool) (*fileFilter, error) {
 	var err error
 
 	refile, err := os.Open(path)
@@ -130,6 +212,7 @@ func joinedExpFilter(path string, inverse bool) (func(string) bool, error) {
 	// regbuf := new(bytes.Buffer)
 	regbuf := bytes.NewBufferString("(")
 	r := bufio.NewReader(refile)
+	rules := &fileRules{} // lines to tell which one matched
 
 LOOP:
 	for {
@@ -156,18 +239,69 @@ LOOP:
 		if _, err := regbuf.WriteString(line + "|"); err != nil {
 			return nil, err
 		}
+		rules.lines = append(rules.lines, line)
 	}
 
 	b := regbuf.Bytes()
 	b[len(b)-1] = byte(')')
 	joinedexp := string(b)
 
-	re, err := regexp.Compile(joinedexp)
+	re, err := compileLiteralRegexp(joinedexp)
 	if err != nil {
 		return nil, err
 	}
 
-	return func(s string) bool {
-		return (re.FindStringIndex(s) != nil) == !inverse
-	}, nil
+	return &fileFilter{rules: rules, joined: true, eval: func(s string, mode statsMode, match bool) (bool, *Match) {
+		matched := re.MatchString(s)
+		match = match && !inverse
+		// rules are told apart once, only if counted or for the match
+		i := rules.index(s, matched && (mode != STATS_NONE || match))
+		if i >= 0 {
+			rules.counters[i].countRule(mode, true, inverse)
+		}
+		if matched == inverse {
+			return false, nil
+		}
+		if !match {
+			return true, nil
+		}
+		rule := path
+		if i >= 0 {
+			rule = rules.lines[i]
+		}
+		return true, &Match{Rule: rule, Spans: regexpSpans(re.re, s)}
+	}}, nil
+}
+
+// fileRules is lines of a filter file, the regexps of which are compiled one
+// by one on the first use for the joined regexp, since they are needed only for
+// lines it matched.
+type fileRules struct {
+	once     sync.Once
+	lines    []string
+	regexps  []*literalRegexp // nil for a line which is not a regexp alone
+	counters []*counter       // of lines, set by regexpFilter
+}
+
+// returns the index of the first line which matches s alone if matched, the
+// joined regexp matched s, or -1
+func (r *fileRules) index(s string, matched bool) int {
+	if !matched {
+		return -1
+	}
+	r.once.Do(func() {
+		if r.regexps != nil {
+			return
+		}
+		r.regexps = make([]*literalRegexp, len(r.lines))
+		for i, line := range r.lines {
+			r.regexps[i], _ = compileLiteralRegexp(line)
+		}
+	})
+	for i, re := range r.regexps {
+		if re != nil && re.MatchString(s) {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,78 @@
+package lotf
+
+import (
+	"github.com/golang/glog"
+	"path/filepath"
+)
+
+// Reloader is Filter, Mapper or anything reloaded from its source.
+type Reloader interface {
+	Reload() error
+}
+
+// Sourcer is implemented by Filter and Mapper which are read from files, to be
+// reloaded by TailWatcher.WatchReload on their change.
+type Sourcer interface {
+	Sources() []string
+}
+
+// appends the sources of v if it is Sourcer
+func appendSources(files []string, v interface{}) []string {
+	if s, ok := v.(Sourcer); ok {
+		files = append(files, s.Sources()...)
+	}
+	return files
+}
+
+// WatchReload reloads r when one of its source files is written or replaced
+// by rename(2), if r is Sourcer. A failed reload keeps the previous one of r,
+// and the error is sent to Error with Op "reload". On success, tails keeping
+// TailOptions.RawLines of which filter or mapper reads the file are rebuilt by
+// Tail.Refilter. The files are the ones r returns now, e.g. files included by
+// rules later are not watched. r should not be passed twice, or it is reloaded
+// twice.
+func (tw *TailWatcher) WatchReload(r Reloader) error {
+	if tw.closed {
+		return &TailError{Op: "reload", Severity: SEVERITY_ERROR, Err: ErrorClosed}
+	}
+	files := appendSources(nil, r)
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for _, name := range files {
+		absname, err := filepath.Abs(name)
+		if err != nil {
+			if glog.V(1) {
+				glog.Infof("filepath.Abs(): %s", err)
+			}
+			return err
+		}
+		if err := tw.watchDir(filepath.Dir(absname)); err != nil {
+			return &TailError{Path: absname, Op: "reload", Severity: SEVERITY_ERROR, Err: err}
+		}
+		tw.reloads[absname] = append(tw.reloads[absname], r)
+	}
+	return nil
+}
+
+// reloads the ones watching name, called from follow
+func (tw *TailWatcher) reload(name string) {
+	tw.mu.Lock()
+	rs := tw.reloads[name]
+	tw.mu.Unlock()
+
+	reloaded := false
+	for _, r := range rs {
+		if err := r.Reload(); err != nil {
+			tw.errch <- &TailError{Path: name, Op: "reload", Severity: SEVERITY_ERROR, Err: err}
+			continue
+		}
+		if glog.V(1) {
+			glog.Infof("reloaded by %s: %v", name, r)
+		}
+		reloaded = true
+	}
+	if reloaded {
+		tw.refilter(name)
+	}
+}
@@ -0,0 +1,92 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("a1\nb1\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	// in the same directory as the watching file
+	filtername := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(filtername, []byte("^a\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	errch := make(chan error, 1)
+	go func() {
+		for err := range tw.Error {
+			errch <- err
+		}
+	}()
+
+	filter, err := NewFilter("substr:1 || " + filtername)
+	if err != nil {
+		t.Fatalf("NewFilter: %s", err)
+	}
+	tail, err := tw.Add(fname, 8, filter, 10)
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	if err := tw.WatchReload(filter); err != nil {
+		t.Fatalf("WatchReload: %s", err)
+	}
+
+	// written in place
+	if err := ioutil.WriteFile(filtername, []byte("^b\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	for i := 0; !filter.Filter("b2"); i++ {
+		if i > 100 {
+			t.Fatalf("filter is not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	wfile.WriteString("a2\nb2\n")
+	wfile.Close()
+	for _, s := range []string{"a1", "b1", "b2"} {
+		if line := tail.WaitNextLine(); line == nil || line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+
+	// bad edit replaced by rename(2) keeps the previous one
+	if err := ioutil.WriteFile(filtername+".tmp", []byte("(\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	if err := os.Rename(filtername+".tmp", filtername); err != nil {
+		t.Fatalf("failed to rename: %s", err)
+	}
+	select {
+	case err := <-errch:
+		if te, ok := err.(*TailError); !ok || te.Op != "reload" || te.Path != filtername {
+			t.Fatalf("expect reload error of %s, but got: %s", filtername, err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("no reload error")
+	}
+	if !filter.Filter("b3") || filter.Filter("a3") {
+		t.Fatalf("expect the previous filter kept")
+	}
+}
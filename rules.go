@@ -0,0 +1,347 @@
+package lotf
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// the depth of nested include, to stop a loop
+const RULES_MAX_INCLUDE = 16
+
+type RulesMode int
+
+const (
+	RULES_ANY RulesMode = iota // first matching rule decides
+	RULES_ALL                  // all + rules match and no - rule matches
+)
+
+// a line of a rules file, or an included file
+type rule struct {
+	include bool
+	re      *literalRegexp
+	literal string   // used instead of re if not empty
+	fold    bool     // literal is lower cased
+	source  string   // the line as written, reported as Match.Rule
+	group   *ruleSet // rules of the included file, others are not used
+	counter *counter
+}
+
+func (r *rule) match(line string) bool {
+	if len(r.literal) == 0 {
+		return r.re.MatchString(line)
+	}
+	if r.fold {
+		line = strings.ToLower(line)
+	}
+	return strings.Contains(line, r.literal)
+}
+
+type ruleSet struct {
+	mode  RulesMode
+	rules []*rule
+	files []string // the file and included ones
+}
+
+// returns matched parts of line, which r matches
+func (r *rule) spans(line string) []Span {
+	if len(r.literal) == 0 {
+		return regexpSpans(r.re.re, line)
+	}
+	return substrSpans(line, r.literal, r.fold)
+}
+
+// returns whether line passes, and the + rules which made it pass
+func (rs *ruleSet) filter(line string, mode statsMode) (bool, []*rule) {
+	decided, pass, by := rs.eval(line, mode)
+	return decided && pass, by
+}
+
+// returns whether rs decided line, whether it passes, and the + rules which
+// made it pass. In all mode rs decides only when it passes, so that an
+// included file of all mode is like a + rule in any mode.
+func (rs *ruleSet) eval(line string, mode statsMode) (bool, bool, []*rule) {
+	if rs.mode == RULES_ALL {
+		var by []*rule
+		for _, r := range rs.rules {
+			if r.group != nil {
+				decided, pass, rules := r.group.eval(line, mode)
+				if !decided || !pass {
+					return false, false, nil
+				}
+				by = append(by, rules...)
+				continue
+			}
+			matched := r.match(line)
+			if !r.counter.countRule(mode, matched, matched != r.include) {
+				return false, false, nil
+			}
+			if r.include {
+				by = append(by, r)
+			}
+		}
+		return true, true, by
+	}
+	for _, r := range rs.rules {
+		if r.group != nil {
+			// rules of all mode do not drop a line by their rejection here
+			gmode := mode
+			if r.group.mode == RULES_ALL {
+				gmode = mode.nested()
+			}
+			if decided, pass, by := r.group.eval(line, gmode); decided {
+				return true, pass, by
+			}
+			continue
+		}
+		matched := r.match(line)
+		r.counter.countRule(mode, matched, matched && !r.include)
+		if matched && r.include {
+			return true, true, []*rule{r}
+		} else if matched {
+			return true, false, nil
+		}
+	}
+	return false, false, nil
+}
+
+// returns the rules of rs and included files in order
+func (rs *ruleSet) all() []*rule {
+	var rules []*rule
+	for _, r := range rs.rules {
+		if r.group != nil {
+			rules = append(rules, r.group.all()...)
+		} else {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// sets counters of rules, taking over the ones of prev if not nil
+func (rs *ruleSet) takeCounters(prev *ruleSet) {
+	var prevRules, rules []string
+	var counters []*counter
+	if prev != nil {
+		for _, r := range prev.all() {
+			prevRules = append(prevRules, r.source)
+			counters = append(counters, r.counter)
+		}
+	}
+	all := rs.all()
+	for _, r := range all {
+		rules = append(rules, r.source)
+	}
+	for i, c := range takeCounters(prevRules, counters, rules) {
+		all[i].counter = c
+	}
+}
+
+// rulesFilter is Filter of a rules file, see RulesFilter.
+type rulesFilter struct {
+	path    string
+	set     atomic.Value // *ruleSet, swapped by Reload
+	counter counter
+}
+
+// RulesFilter creates Filter from a rules file, registered as "rules:" scheme.
+// Each line of the file is one of:
+//
+//	+[flags] <regexp>   include lines matching regexp
+//	-[flags] <regexp>   exclude lines matching regexp
+//	+<regexp>           the same with no flags, e.g. +^GET
+//	include <file>      rules in file, relative to the including file
+//	mode any|all        any (default) or all, of the file it appears in
+//	# comment
+//
+// flags are i for case-insensitive and l for a literal string instead of
+// regexp. A rule is of flags only if the first word is + or - and flags. In
+// any mode rules are evaluated in order and the first matching rule decides, a
+// line matching no rule is excluded so that "+ ." at the end passes the rest.
+// In all mode a line passes if it matches all + rules and no - rule. An
+// included file is evaluated by its own mode: in any mode its first matching
+// rule decides if any, in all mode it is like a + rule which matches a line
+// the file passes.
+func RulesFilter(path string) (Filter, error) {
+	set, err := parseRules(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &rulesFilter{path: path}
+	set.takeCounters(nil)
+	f.set.Store(set)
+	return f, nil
+}
+
+func init() {
+	RegisterFilter("rules", RulesFilter)
+}
+
+func (f *rulesFilter) Filter(line string) bool {
+	return f.filterBy(line, STATS_TOP)
+}
+
+func (f *rulesFilter) filterBy(line string, mode statsMode) bool {
+	pass, _ := f.set.Load().(*ruleSet).filter(line, mode)
+	return f.counter.countTop(mode, pass)
+}
+
+// Match returns the + rule which matched as it is written, or all + rules
+// joined by " && " in all mode.
+func (f *rulesFilter) Match(line string) (bool, *Match) {
+	return f.matchBy(line, STATS_TOP)
+}
+
+func (f *rulesFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	pass, by := f.set.Load().(*ruleSet).filter(line, mode)
+	if !f.counter.countTop(mode, pass) {
+		return false, nil
+	}
+	var m *Match
+	for _, r := range by {
+		if m == nil {
+			m = &Match{Rule: r.source}
+		} else {
+			m.Rule += " && " + r.source
+		}
+		m.Spans = mergeSpans(m.Spans, r.spans(line))
+	}
+	return true, m
+}
+
+// Reload parses the file again, and keeps the current rules on error.
+func (f *rulesFilter) Reload() error {
+	set, err := parseRules(f.path)
+	if err != nil {
+		return err
+	}
+	set.takeCounters(f.set.Load().(*ruleSet))
+	f.set.Store(set)
+	return nil
+}
+
+// Stats returns the rules as written, include lines are not.
+func (f *rulesFilter) Stats() *FilterStats {
+	stats := f.counter.filterStats(f.String())
+	for _, r := range f.set.Load().(*ruleSet).all() {
+		stats.Rules = append(stats.Rules, r.counter.ruleStats(r.source))
+	}
+	return stats
+}
+
+// Sources returns the file and included ones at the last successful load.
+func (f *rulesFilter) Sources() []string {
+	return f.set.Load().(*ruleSet).files
+}
+
+func (f *rulesFilter) String() string {
+	return "rules:" + f.path
+}
+
+func parseRules(path string) (*ruleSet, error) {
+	return parseRuleSet(path, 0)
+}
+
+// parses the file of path, files of the returned has included ones too
+func parseRuleSet(path string, depth int) (*ruleSet, error) {
+	rs := &ruleSet{mode: RULES_ANY}
+	if err := rs.parse(path, depth); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (rs *ruleSet) parse(path string, depth int) error {
+	if depth > RULES_MAX_INCLUDE {
+		return fmt.Errorf("%s: too deep include", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	rs.files = append(rs.files, path)
+
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimLeft(strings.TrimRight(scanner.Text(), "\r"), " \t")
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := rs.parseLine(path, line, depth); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (rs *ruleSet) parseLine(path, line string, depth int) error {
+	head, arg := nextField(line)
+	switch head {
+	case "include":
+		if len(arg) == 0 {
+			return fmt.Errorf("no file to include")
+		}
+		if !filepath.IsAbs(arg) {
+			arg = filepath.Join(filepath.Dir(path), arg)
+		}
+		group, err := parseRuleSet(arg, depth+1)
+		if err != nil {
+			return err
+		}
+		rs.rules = append(rs.rules, &rule{group: group})
+		rs.files = append(rs.files, group.files...)
+		return nil
+	case "mode":
+		switch arg {
+		case "any":
+			rs.mode = RULES_ANY
+		case "all":
+			rs.mode = RULES_ALL
+		default:
+			return fmt.Errorf("unknown mode: %s", arg)
+		}
+		return nil
+	}
+
+	if head[0] != '+' && head[0] != '-' {
+		return fmt.Errorf("rule must start with + or -: %s", line)
+	}
+	r := &rule{include: head[0] == '+', source: line}
+	flags := head[1:]
+	if len(arg) == 0 || strings.Trim(flags, "il") != "" {
+		// +<pattern> with no space
+		flags, arg = "", line[1:]
+	}
+	if len(arg) == 0 {
+		return fmt.Errorf("no pattern")
+	}
+	literal := false
+	for _, c := range flags {
+		switch c {
+		case 'i':
+			r.fold = true
+		case 'l':
+			literal = true
+		}
+	}
+	if literal {
+		r.literal = arg
+		if r.fold {
+			r.literal = strings.ToLower(arg)
+		}
+	} else {
+		if r.fold {
+			arg = "(?i)" + arg
+		}
+		var err error
+		if r.re, err = compileLiteralRegexp(arg); err != nil {
+			return err
+		}
+	}
+	rs.rules = append(rs.rules, r)
+	return nil
+}
@@ -0,0 +1,96 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRulesFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	rulesFile := filepath.Join(dir, "rules")
+	for name, content := range map[string]string{
+		"included": "-l /health\n",
+		"all":      "mode all\n+ ^[A-Z]\n-i /x\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("failed to write rules: %s", err)
+		}
+	}
+
+	lines := []string{"GET /health", "GET /index", "post /login", "DELETE /x", "a.b"}
+	for _, c := range []struct {
+		rules  string
+		expect string
+	}{
+		{"# comment\n\ninclude included\n+ ^GET\n", "GET /index"},
+		{"+ ^GET\n-l /health\n", "GET /health,GET /index"},
+		{"-l /health\n+i ^post\n- ^DEL\n+ .\n", "GET /index,post /login,a.b"},
+		{"+il .B\n", "a.b"},
+		{"mode all\n+ ^[A-Z]\n- health\n", "GET /index,DELETE /x"},
+		{"+^GET\n-/login\n+i^post\n+x\n", "GET /health,GET /index,DELETE /x"},
+		// the mode of the included file is not of this one
+		{"include all\n+l .\n", "GET /health,GET /index,a.b"},
+		{"include included\ninclude all\n-i ^get\n+ .\n", "GET /index,post /login,DELETE /x,a.b"},
+		{"mode all\ninclude all\n- index\n", "GET /health"},
+	} {
+		if err := ioutil.WriteFile(rulesFile, []byte(c.rules), 0666); err != nil {
+			t.Fatalf("failed to write rules: %s", err)
+		}
+		f, err := NewFilter("rules:" + rulesFile)
+		if err != nil {
+			t.Fatalf("NewFilter(%q): %s", c.rules, err)
+		}
+		var passed []string
+		for _, line := range lines {
+			if f.Filter(line) {
+				passed = append(passed, line)
+			}
+		}
+		if s := strings.Join(passed, ","); s != c.expect {
+			t.Fatalf("rules: %q, expect: %s, but got: %s", c.rules, c.expect, s)
+		}
+	}
+
+	for _, c := range []struct {
+		rules  string
+		expect string
+	}{
+		{"+ a\n\n+ (\n", rulesFile + ":3: "},
+		{"+\n", rulesFile + ":1: no pattern"},
+		{"a\n", rulesFile + ":1: rule must start with + or -"},
+		{"mode some\n", rulesFile + ":1: unknown mode: some"},
+		{"include rules\n", "too deep include"},
+	} {
+		if err := ioutil.WriteFile(rulesFile, []byte(c.rules), 0666); err != nil {
+			t.Fatalf("failed to write rules: %s", err)
+		}
+		if _, err := RulesFilter(rulesFile); err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Fatalf("rules: %q, expect error: %s, but got: %v", c.rules, c.expect, err)
+		}
+	}
+
+	// an invalid edit keeps the current rules
+	if err := ioutil.WriteFile(rulesFile, []byte("+ a\n"), 0666); err != nil {
+		t.Fatalf("failed to write rules: %s", err)
+	}
+	f, err := RulesFilter(rulesFile)
+	if err != nil {
+		t.Fatalf("RulesFilter: %s", err)
+	}
+	if err := ioutil.WriteFile(rulesFile, []byte("+ (\n"), 0666); err != nil {
+		t.Fatalf("failed to write rules: %s", err)
+	}
+	if err := f.Reload(); err == nil {
+		t.Fatalf("expect error reloading invalid rules")
+	}
+	if !f.Filter("a") {
+		t.Fatalf("expect the current rules kept")
+	}
+}
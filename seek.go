@@ -0,0 +1,222 @@
+package lotf
+
+import (
+	"bufio"
+	"github.com/golang/glog"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultTimeLayouts is used to parse the timestamp at the head of lines if
+// TailOptions.TimeLayouts is not specified.
+var DefaultTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	time.Stamp, // syslog, without year
+}
+
+const maxTimestampLen = 64
+
+// sets the current year to t if it has no year, or last year if it would be
+// in the future.
+func withYear(t time.Time) time.Time {
+	if t.Year() != 0 {
+		return t
+	}
+	now := time.Now()
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 0, 1)) { // last year's
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// parses the timestamp at the head of line by trying prefixes which end before
+// a white space, since the value is not always the same length as the layout.
+// The current year is used if the layout has no year.
+func parseTimestamp(line []byte, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		for i := 1; i <= len(line) && i <= maxTimestampLen; i++ {
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				continue
+			}
+			t, err := time.ParseInLocation(layout, string(line[:i]), time.Local)
+			if err != nil {
+				continue
+			}
+			return withYear(t), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// returns the head offset of the line which contains offset
+func alignLine(file *os.File, offset int64) (int64, error) {
+	tr, err := NewTailReader(io.NewSectionReader(file, 0, offset))
+	if err == ErrorEmpty {
+		return 0, nil
+	} else if err != nil {
+		return -1, err
+	}
+	line, err := tr.PrevBytes('\n')
+	if err == ErrorStartOfFile {
+		return 0, nil
+	} else if err != nil {
+		return -1, err
+	}
+	if len(line) > 0 && line[0] == '\n' {
+		return tr.Tell() + 1, nil
+	}
+	return 0, nil
+}
+
+// scans lines from offset start, which should be the head of a line, and
+// returns the head offset, the next line head offset and the timestamp of the
+// first line which has the timestamp. found is false if no such line starts
+// before limit.
+func nextTimestamp(file *os.File, start, limit int64, layouts []string) (head, next int64, t time.Time, found bool, err error) {
+	r := bufio.NewReader(io.NewSectionReader(file, start, 1<<62))
+	head = start
+	for head < limit {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return -1, -1, t, false, err
+		}
+		next = head + int64(len(line))
+		if t, found = parseTimestamp(line, layouts); found {
+			return head, next, t, true, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		head = next
+	}
+	return -1, -1, t, false, nil
+}
+
+// SeekTime returns the head offset of the first line of which timestamp is
+// the same as or after since, by binary search over the file. Lines are
+// supposed to be in time order and lines which have no timestamp, like a
+// stack trace, belong to the previous one. The file size is returned if there
+// is no such line.
+func SeekTime(file *os.File, since time.Time, layouts []string) (int64, error) {
+	if layouts == nil {
+		layouts = DefaultTimeLayouts
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("File.Stat(): %s", err)
+		}
+		return -1, err
+	}
+
+	// the answer is in [lo, hi], lo is always the head of a line
+	lo, hi := int64(0), fi.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		p, err := alignLine(file, mid)
+		if err != nil {
+			return -1, err
+		}
+		if p < lo {
+			p = lo
+		}
+		_, next, t, found, err := nextTimestamp(file, p, hi, layouts)
+		if err != nil {
+			return -1, err
+		}
+		if found && t.Before(since) {
+			lo = next
+		} else {
+			hi = p
+		}
+	}
+
+	// skip lines with no timestamp which belong to older one
+	head, _, _, found, err := nextTimestamp(file, lo, fi.Size(), layouts)
+	if err != nil {
+		return -1, err
+	}
+	if !found {
+		return fi.Size(), nil
+	}
+	return head, nil
+}
+
+// returns the head offset of k th line, 1 origin like tail -n +K. The offset
+// after the last NL is returned if the file has less lines.
+func lineOffset(file *os.File, k int64) (int64, error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(file, 0, 1<<62), BUFSIZ)
+	pos := int64(0)
+	for ; k > 1; k-- {
+		line, err := r.ReadSlice('\n')
+		n := int64(0) // of the line longer than the buffer, read so far
+		for err == bufio.ErrBufferFull {
+			n += int64(len(line))
+			line, err = r.ReadSlice('\n')
+		}
+		if err == io.EOF { // the last line without NL is not counted
+			break
+		} else if err != nil {
+			if glog.V(1) {
+				glog.Infof("File.ReadSlice(): %s", err)
+			}
+			return -1, err
+		}
+		pos += n + int64(len(line))
+	}
+	return pos, nil
+}
+
+// returns the start offset by opts.From and opts.Start, which is clamped to
+// the file size.
+func startOffset(file *os.File, opts *TailOptions) (int64, error) {
+	if opts.From == FROM_LINE {
+		return lineOffset(file, opts.Start)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("File.Stat(): %s", err)
+		}
+		return -1, err
+	}
+	pos := opts.Start
+	if opts.From == FROM_LAST_BYTES {
+		pos = fi.Size() - opts.Start
+	}
+	if pos < 0 {
+		pos = 0
+	} else if pos > fi.Size() {
+		pos = fi.Size()
+	}
+	return pos, nil
+}
+
+// reads lines from the offset pos to the last NL and stores them to q if
+// filter accepts, or by context if not nil. This returns the offset after the
+// last NL.
+func fillLines(file *os.File, pos int64, q *Blockq, filter Filter, context *contextLines) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(file, pos, 1<<62))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return pos, nil
+		} else if err != nil {
+			if glog.V(1) {
+				glog.Infof("File.ReadBytes(): %s", err)
+			}
+			return -1, err
+		}
+		text := string(line[:len(line)-1])
+		match, m := matchLine(filter, text)
+		if context != nil {
+			context.feed(q, nil, nil, &Line{Text: text, Offset: pos, Match: m}, match)
+		} else if match {
+			q.Add(&Line{Text: text, Offset: pos, Match: m})
+		}
+		pos += int64(len(line))
+	}
+}
@@ -0,0 +1,178 @@
+package lotf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeekTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	data := "2026-01-01 00:00:01 a\n" +
+		"2026-01-01 00:00:02 b\n" +
+		"  trace of b\n" +
+		"2026-01-01 00:00:04 c\n" +
+		"2026-01-01 00:00:05 d\n" +
+		"2026-01-01 00:00:06 e\n"
+	if err := ioutil.WriteFile(fname, []byte(data), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	defer file.Close()
+
+	at := func(sec int) time.Time {
+		return time.Date(2026, 1, 1, 0, 0, sec, 0, time.Local)
+	}
+	for _, c := range []struct {
+		since  time.Time
+		offset int64
+	}{
+		{at(0), 0},
+		{at(1), 0},
+		{at(2), 22},
+		{at(3), 57},
+		{at(4), 57},
+		{at(6), 101},
+		{at(7), int64(len(data))},
+	} {
+		offset, err := SeekTime(file, c.since, nil)
+		if err != nil {
+			t.Fatalf("SeekTime failed: %s", err)
+		}
+		if offset != c.offset {
+			t.Fatalf("expect offset %d for %s, but got: %d", c.offset, c.since, offset)
+		}
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.AddOptions(fname, 8, nil, 1, &TailOptions{Since: at(3)})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	for _, s := range []string{"2026-01-01 00:00:04 c", "2026-01-01 00:00:05 d", "2026-01-01 00:00:06 e"} {
+		if line := tail.NextLine(); line == nil || line.Text != s {
+			t.Fatalf("expect %s, but got: %v", s, line)
+		}
+	}
+	if line := tail.NextLine(); line != nil {
+		t.Fatalf("expect no more line, but got: %s", line)
+	}
+
+	wfile, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("failed to open testFile: %s", err)
+	}
+	defer wfile.Close()
+	wfile.WriteString("2026-01-01 00:00:07 f\n")
+	if line := tail.WaitNextLine(); line == nil || line.Text != "2026-01-01 00:00:07 f" {
+		t.Fatalf("expect f, but got: %v", line)
+	}
+}
+
+func TestStartFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("l1\nl2\nl3\nl4\nl5\nl6"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	for _, c := range []struct {
+		from    StartFrom
+		start   int64
+		maxline int
+		expect  string
+	}{
+		{FROM_LINE, 1, 10, "l1@0,l2@3,l3@6,l4@9,l5@12,"},
+		{FROM_LINE, 4, 10, "l4@9,l5@12,"},
+		{FROM_LINE, 100, 10, ""},
+		{FROM_LINE, 2, 2, "l4@9,l5@12,"},
+		{FROM_OFFSET, 7, 10, "3@7,l4@9,l5@12,"},
+		{FROM_OFFSET, 100, 10, ""},
+		{FROM_LAST_BYTES, 6, 10, "@11,l5@12,"},
+		{FROM_LAST_BYTES, 5, 10, "l5@12,"},
+		{FROM_LAST_BYTES, 100, 1, "l5@12,"},
+	} {
+		tw, err := NewTailWatcher()
+		if err != nil {
+			t.Fatalf("could not create TailWatcher: %s", err)
+		}
+		go func() {
+			for err := range tw.Error {
+				t.Errorf("error received: %s", err)
+			}
+		}()
+		tail, err := tw.AddOptions(fname, c.maxline, nil, 1, &TailOptions{From: c.from, Start: c.start})
+		if err != nil {
+			t.Fatalf("failed to Add to TailWatcher: %s", err)
+		}
+		lines := make([]*Line, 0)
+		for line := tail.NextLine(); line != nil; line = tail.NextLine() {
+			lines = append(lines, line)
+		}
+		if s := historyString(lines); s != c.expect {
+			t.Fatalf("expect %s from %d of %d, but got: %s", c.expect, c.start, c.from, s)
+		}
+		tw.Close()
+	}
+}
+
+func TestLineOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	long := strings.Repeat("x", BUFSIZ+100)
+
+	for _, c := range []struct {
+		content string
+		k       int64
+		expect  int64
+	}{
+		{"a\n" + long + "\nb", 3, int64(len(long)) + 3},
+		{"a\n" + long + "\nb", 4, int64(len(long)) + 3},
+		// the last line longer than the buffer without NL
+		{"a\n" + long, 3, 2},
+	} {
+		if err := ioutil.WriteFile(fname, []byte(c.content), 0666); err != nil {
+			t.Fatalf("failed to write testFile: %s", err)
+		}
+		file, err := os.Open(fname)
+		if err != nil {
+			t.Fatalf("failed to open testFile: %s", err)
+		}
+		pos, err := lineOffset(file, c.k)
+		file.Close()
+		if err != nil || pos != c.expect {
+			t.Fatalf("expect %d of line %d, but got: %d, %v", c.expect, c.k, pos, err)
+		}
+	}
+}
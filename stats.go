@@ -0,0 +1,244 @@
+package lotf
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RuleStats is the counts of a rule of a filter, see Statser.
+type RuleStats struct {
+	Rule        string
+	Evaluations int64     // lines the rule was checked against
+	Matches     int64     // lines the rule matched
+	Rejections  int64     // lines the filter dropped by the rule, see Statser
+	FirstSeen   time.Time // when the rule matched first, zero if never
+	LastSeen    time.Time // when the rule matched last, zero if never
+}
+
+// FilterStats is the counts of a filter and its rules, or the ones of the
+// filters it is made of, see Statser.
+type FilterStats struct {
+	Filter      string
+	Evaluations int64 // lines filtered
+	Rejections  int64 // lines dropped
+	Rules       []RuleStats
+}
+
+// Statser is implemented by Filter which counts lines by rule, so that rules
+// never matching or matching too often can be found. Filters created by
+// NewFilter implement this. A line is counted once by the filter it is called
+// on, the top one, and by the rules it was checked against under it. A rule
+// counts a rejection only if it drops the line by itself, not under ! or ||
+// terms. Lines are counted once when a tail ingests them, by its filter and
+// the view filters set on it or its clones, not each time a view reads them.
+// Lines read again by History or rebuilt by Tail.Refilter are not counted.
+// Counts of a rule are kept over Reload if the rule is not changed.
+type Statser interface {
+	Stats() *FilterStats
+}
+
+// FilterStatsOf returns the stats of f, or nil if f is not Statser.
+func FilterStatsOf(f Filter) *FilterStats {
+	if s, ok := f.(Statser); ok {
+		return s.Stats()
+	}
+	return nil
+}
+
+// statsMode is what an evaluation of a filter counts
+type statsMode int
+
+const (
+	STATS_NONE  statsMode = iota // lines read again, nothing is counted
+	STATS_RULES                  // evaluations and matches of rules
+	STATS_DROPS                  // rejections of rules too, which drop lines
+	STATS_TOP                    // the filter itself too
+)
+
+// returns the mode of terms which drop a line by their rejection, of &&
+func (m statsMode) dropping() statsMode {
+	if m == STATS_TOP {
+		return STATS_DROPS
+	}
+	return m
+}
+
+// returns the mode of terms which do not drop a line by their rejection alone,
+// of || and !
+func (m statsMode) nested() statsMode {
+	if m > STATS_RULES {
+		return STATS_RULES
+	}
+	return m
+}
+
+// evaluator is Filter which counts its stats by mode, so that a filter
+// counts as the top one only when it is not a term of another.
+type evaluator interface {
+	filterBy(line string, mode statsMode) bool
+	matchBy(line string, mode statsMode) (bool, *Match)
+}
+
+// filters line by filter counting by mode if it is evaluator
+func evalFilter(filter Filter, line string, mode statsMode) bool {
+	if e, ok := filter.(evaluator); ok {
+		return e.filterBy(line, mode)
+	}
+	return filter.Filter(line)
+}
+
+// returns what matchLine returns counting by mode if filter is evaluator
+func evalMatch(filter Filter, line string, mode statsMode) (bool, *Match) {
+	if e, ok := filter.(evaluator); ok {
+		return e.matchBy(line, mode)
+	}
+	return matchLine(filter, line)
+}
+
+// counts lines of a filter or a rule atomically since filters are shared,
+// 64bit fields are at first to be aligned
+type counter struct {
+	evaluations int64
+	matches     int64
+	rejections  int64
+	first       int64 // UnixNano of the first match, 0 if none
+	last        int64
+}
+
+// counts a line, and returns whether it passes as rejected is false
+func (c *counter) count(matched, rejected bool) bool {
+	atomic.AddInt64(&c.evaluations, 1)
+	if matched {
+		atomic.AddInt64(&c.matches, 1)
+		now := time.Now().UnixNano()
+		atomic.CompareAndSwapInt64(&c.first, 0, now)
+		atomic.StoreInt64(&c.last, now)
+	}
+	if rejected {
+		atomic.AddInt64(&c.rejections, 1)
+	}
+	return !rejected
+}
+
+// counts a line of the filter only if it is the top one, and returns pass
+func (c *counter) countTop(mode statsMode, pass bool) bool {
+	if mode == STATS_TOP {
+		c.count(pass, !pass)
+	}
+	return pass
+}
+
+// counts a line of a rule by mode, and returns whether it passes as rejected
+// is false
+func (c *counter) countRule(mode statsMode, matched, rejected bool) bool {
+	if mode >= STATS_RULES {
+		c.count(matched, rejected && mode >= STATS_DROPS)
+	}
+	return !rejected
+}
+
+func unixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func (c *counter) ruleStats(rule string) RuleStats {
+	return RuleStats{
+		Rule:        rule,
+		Evaluations: atomic.LoadInt64(&c.evaluations),
+		Matches:     atomic.LoadInt64(&c.matches),
+		Rejections:  atomic.LoadInt64(&c.rejections),
+		FirstSeen:   unixNano(atomic.LoadInt64(&c.first)),
+		LastSeen:    unixNano(atomic.LoadInt64(&c.last)),
+	}
+}
+
+func (c *counter) filterStats(filter string) *FilterStats {
+	return &FilterStats{
+		Filter:      filter,
+		Evaluations: atomic.LoadInt64(&c.evaluations),
+		Rejections:  atomic.LoadInt64(&c.rejections),
+	}
+}
+
+// returns stats of c for f, with the rules of filters
+func joinStats(f Filter, c *counter, filters []Filter) *FilterStats {
+	stats := c.filterStats(filterString(f))
+	for _, filter := range filters {
+		if s := FilterStatsOf(filter); s != nil {
+			stats.Rules = append(stats.Rules, s.Rules...)
+		}
+	}
+	return stats
+}
+
+// returns counters of rules, taking over the ones of the same rule in prev
+// which are counters of prevRules
+func takeCounters(prevRules []string, prev []*counter, rules []string) []*counter {
+	taken := make(map[string][]*counter)
+	for i, rule := range prevRules {
+		taken[rule] = append(taken[rule], prev[i])
+	}
+	counters := make([]*counter, len(rules))
+	for i, rule := range rules {
+		if cs := taken[rule]; len(cs) > 0 {
+			counters[i], taken[rule] = cs[0], cs[1:]
+		} else {
+			counters[i] = &counter{}
+		}
+	}
+	return counters
+}
+
+// statsFilter counts lines of a Filter which is not Statser as a rule.
+type statsFilter struct {
+	filter  Filter
+	counter counter
+}
+
+// returns f as is if it is Statser, or wrapped by statsFilter
+func withStats(f Filter) Filter {
+	if _, ok := f.(Statser); ok {
+		return f
+	}
+	return &statsFilter{filter: f}
+}
+
+func (f *statsFilter) Filter(line string) bool {
+	return f.filterBy(line, STATS_TOP)
+}
+
+func (f *statsFilter) filterBy(line string, mode statsMode) bool {
+	pass := f.filter.Filter(line)
+	return f.counter.countRule(mode, pass, !pass)
+}
+
+func (f *statsFilter) Match(line string) (bool, *Match) {
+	return f.matchBy(line, STATS_TOP)
+}
+
+func (f *statsFilter) matchBy(line string, mode statsMode) (bool, *Match) {
+	pass, m := matchLine(f.filter, line)
+	f.counter.countRule(mode, pass, !pass)
+	return pass, m
+}
+
+func (f *statsFilter) Reload() error {
+	return f.filter.Reload()
+}
+
+func (f *statsFilter) Sources() []string {
+	return appendSources(nil, f.filter)
+}
+
+func (f *statsFilter) String() string {
+	return filterString(f.filter)
+}
+
+func (f *statsFilter) Stats() *FilterStats {
+	stats := f.counter.filterStats(f.String())
+	stats.Rules = []RuleStats{f.counter.ruleStats(f.String())}
+	return stats
+}
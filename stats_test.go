@@ -0,0 +1,93 @@
+package lotf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// returns "<filter> <evaluations>/<rejections>" and "<rule> <evaluations>/<matches>/<rejections>"
+// of rules, joined by ", "
+func statsString(s *FilterStats) string {
+	items := []string{fmt.Sprintf("%s %d/%d", s.Filter, s.Evaluations, s.Rejections)}
+	for _, r := range s.Rules {
+		items = append(items, fmt.Sprintf("%s %d/%d/%d", r.Rule, r.Evaluations, r.Matches, r.Rejections))
+		if r.Matches > 0 && (r.FirstSeen.IsZero() || r.LastSeen.Before(r.FirstSeen)) {
+			items = append(items, "invalid seen")
+		} else if r.Matches == 0 && !r.FirstSeen.IsZero() {
+			items = append(items, "seen without match")
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+func TestFilterStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	rulesFile := filepath.Join(dir, "rules")
+	filterFile := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(rulesFile, []byte("-l /health\n+ ^GET\n+ ^DEL\n"), 0666); err != nil {
+		t.Fatalf("failed to write rules: %s", err)
+	}
+	if err := ioutil.WriteFile(filterFile, []byte("^a\nb\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+
+	for _, c := range []struct {
+		spec   string
+		lines  []string
+		expect string
+	}{
+		{"rules:" + rulesFile, []string{"GET /health", "GET /a", "POST /b", "GET /c"},
+			"rules:" + rulesFile + " 4/2, -l /health 4/1/1, + ^GET 3/2/0, + ^DEL 1/0/0"},
+		{filterFile, []string{"a1", "xb", "ab", "c"}, filterFile + " 4/1, ^a 4/2/0, b 4/1/0"},
+		{"!" + filterFile, []string{"a1", "c"}, "!" + filterFile + " 2/1, ^a 2/1/0, b 2/0/0"},
+		// terms of || and ! do not drop a line by themselves
+		{"substr:x || !regexp:y", []string{"x", "y", "z"},
+			"substr:x || !regexp:y 3/1, substr:x 3/1/0, regexp:y 2/1/0"},
+		{"substr:a && !substr:b", []string{"a", "ab", "c"},
+			"substr:a && !substr:b 3/2, substr:a 3/2/1, substr:b 2/1/0"},
+	} {
+		f, err := NewFilter(c.spec)
+		if err != nil {
+			t.Fatalf("NewFilter(%s): %s", c.spec, err)
+		}
+		for i, line := range c.lines {
+			// Filter and Match are counted the same
+			if i%2 == 0 {
+				f.Filter(line)
+			} else {
+				matchLine(f, line)
+			}
+			// read again, not counted
+			evalMatch(f, line, STATS_NONE)
+		}
+		if s := statsString(FilterStatsOf(f)); s != c.expect {
+			t.Fatalf("spec: %s, expect: %s, but got: %s", c.spec, c.expect, s)
+		}
+	}
+
+	// the rule not changed is taken over
+	f, _ := NewFilter("rules:" + rulesFile)
+	f.Filter("GET /health")
+	if err := ioutil.WriteFile(rulesFile, []byte("-l /health\n+ ^POST\n"), 0666); err != nil {
+		t.Fatalf("failed to write rules: %s", err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	expect := "rules:" + rulesFile + " 1/1, -l /health 1/1/1, + ^POST 0/0/0"
+	if s := statsString(FilterStatsOf(f)); s != expect {
+		t.Fatalf("expect: %s, but got: %s", expect, s)
+	}
+
+	if FilterStatsOf(substrFilter("x")) != nil {
+		t.Fatalf("expect no stats of a filter not Statser")
+	}
+}
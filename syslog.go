@@ -0,0 +1,373 @@
+package lotf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_MAXLEN      = 65536 // max length of a message
+	SYSLOG_DEFAULT_PRI = 13    // user.notice, for a message which has no PRI
+)
+
+// SyslogMessage is a message of RFC 3164 or RFC 5424. Fields which are not
+// in the message are empty, and Timestamp is the received time if it has none.
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string
+	Message        string
+}
+
+// String returns the message formatted in the same manner as a syslog file,
+// "Jan _2 15:04:05 host app[pid]: message", so that it can be filtered like a
+// file.
+func (m *SyslogMessage) String() string {
+	var b bytes.Buffer
+	b.WriteString(m.Timestamp.Local().Format(time.Stamp))
+	if len(m.Hostname) > 0 {
+		b.WriteString(" " + m.Hostname)
+	}
+	if len(m.AppName) > 0 {
+		b.WriteString(" " + m.AppName)
+		if len(m.ProcID) > 0 {
+			b.WriteString("[" + m.ProcID + "]")
+		}
+		b.WriteString(":")
+	}
+	if len(m.StructuredData) > 0 {
+		b.WriteString(" " + m.StructuredData)
+	}
+	b.WriteString(" " + m.Message)
+	return b.String()
+}
+
+// returns the head token and the rest after a space
+func nextField(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// "-" means nil value in RFC 5424
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// returns STRUCTURED-DATA of RFC 5424 at the head of s and the rest
+func structuredData(s string) (string, string) {
+	if strings.HasPrefix(s, "-") {
+		return "", strings.TrimPrefix(s[1:], " ")
+	}
+	i, inValue := 0, false
+	for i < len(s) && s[i] == '[' {
+		for i++; i < len(s); i++ {
+			if inValue && s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				inValue = !inValue
+			} else if s[i] == ']' && !inValue {
+				i++
+				break
+			}
+		}
+	}
+	return s[:i], strings.TrimPrefix(s[i:], " ")
+}
+
+// returns PRI at the head of s and the rest, or false if s has no PRI
+func syslogPri(s string) (int, string, bool) {
+	if strings.HasPrefix(s, "<") {
+		if i := strings.IndexByte(s, '>'); i > 1 && i <= 4 {
+			if n, err := strconv.Atoi(s[1:i]); err == nil && n < 192 {
+				return n, s[i+1:], true
+			}
+		}
+	}
+	return 0, s, false
+}
+
+// returns true if s starts with PRI or TIMESTAMP of RFC 3164, otherwise all of
+// s is the message for ParseSyslog
+func hasSyslogHeader(s string) bool {
+	if _, _, found := syslogPri(s); found {
+		return true
+	}
+	if len(s) < len(time.Stamp) {
+		return false
+	}
+	_, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], time.Local)
+	return err == nil
+}
+
+// ParseSyslog parses a message of RFC 5424 or RFC 3164. This never fails since
+// RFC 3164 says anything can be a message, a part which can not be parsed is
+// regarded as the message body.
+func ParseSyslog(b []byte) *SyslogMessage {
+	s := strings.TrimRight(string(b), "\r\n\x00")
+	m := &SyslogMessage{Timestamp: time.Now()}
+
+	pri, s, found := syslogPri(s)
+	if !found {
+		pri = SYSLOG_DEFAULT_PRI
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+
+	if strings.HasPrefix(s, "1 ") { // RFC 5424, VERSION 1
+		var ts, field string
+		ts, s = nextField(s[2:])
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			m.Timestamp = t
+		}
+		field, s = nextField(s)
+		m.Hostname = nilValue(field)
+		field, s = nextField(s)
+		m.AppName = nilValue(field)
+		field, s = nextField(s)
+		m.ProcID = nilValue(field)
+		field, s = nextField(s)
+		m.MsgID = nilValue(field)
+		m.StructuredData, s = structuredData(s)
+		m.Message = strings.TrimPrefix(s, "\xef\xbb\xbf") // BOM
+		return m
+	}
+
+	// RFC 3164, TIMESTAMP HOSTNAME TAG: MSG
+	if len(s) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], time.Local); err == nil {
+			m.Timestamp = withYear(t)
+			m.Hostname, s = nextField(strings.TrimPrefix(s[len(time.Stamp):], " "))
+		}
+	}
+	if tag, rest := nextField(s); strings.HasSuffix(tag, ":") {
+		tag = tag[:len(tag)-1]
+		if i := strings.IndexByte(tag, '['); i > 0 && strings.HasSuffix(tag, "]") {
+			m.AppName, m.ProcID = tag[:i], tag[i+1:len(tag)-1]
+		} else {
+			m.AppName = tag
+		}
+		s = rest
+	}
+	m.Message = s
+	return m
+}
+
+// SyslogSource is Source which receives syslog messages from network and
+// yields them as lines formatted by SyslogMessage.String.
+type SyslogSource struct {
+	url      string
+	conn     net.PacketConn // udp, unixgram
+	listener net.Listener   // tcp, unix
+	mu       sync.Mutex     // to sync ingest, conns and closed
+	conns    map[net.Conn]bool
+	closed   bool
+	offset   int64 // sum of line length, as if they are in a file
+}
+
+// NewSyslogSource starts listening on url, which is one of udp://host:port,
+// tcp://host:port, unixgram:///path or unix:///path. Messages on stream are
+// framed by octet counting of RFC 6587 or by NL.
+func NewSyslogSource(url string) (*SyslogSource, error) {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid syslog url: %s", url)
+	}
+	network, addr := url[:i], url[i+3:]
+
+	s := &SyslogSource{url: url, conns: make(map[net.Conn]bool)}
+	var err error
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		s.conn, err = net.ListenPacket(network, addr)
+	case "tcp", "tcp4", "tcp6", "unix":
+		s.listener, err = net.Listen(network, addr)
+	default:
+		return nil, fmt.Errorf("unknown syslog network: %s", network)
+	}
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("Listen(%s, %s): %s", network, addr, err)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSource) Name() string {
+	return s.url
+}
+
+// Addr returns the listening address.
+func (s *SyslogSource) Addr() net.Addr {
+	if s.conn != nil {
+		return s.conn.LocalAddr()
+	}
+	return s.listener.Addr()
+}
+
+// parses b received from addr and calls ingest with formatted one
+func (s *SyslogSource) receive(b []byte, addr net.Addr, ingest func(string, int64)) {
+	m := ParseSyslog(b)
+	if len(m.Hostname) == 0 && addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			m.Hostname = host
+		}
+	}
+	text := m.String()
+	s.mu.Lock()
+	offset := s.offset
+	s.offset += int64(len(text)) + 1
+	s.mu.Unlock()
+	ingest(text, offset)
+}
+
+func (s *SyslogSource) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *SyslogSource) Run(ingest func(string, int64), warn func(string, error)) error {
+	if s.conn != nil {
+		return s.runPacket(ingest, warn)
+	}
+	return s.runStream(ingest, warn)
+}
+
+func (s *SyslogSource) runPacket(ingest func(string, int64), warn func(string, error)) error {
+	buf := make([]byte, SYSLOG_MAXLEN)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		s.receive(buf[:n], addr, ingest)
+	}
+}
+
+func (s *SyslogSource) runStream(ingest func(string, int64), warn func(string, error)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.serve(conn, ingest); err != nil && !s.isClosed() {
+				warn("read", err)
+			}
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// reads MSG-LEN and SP of octet counting, which has digits of SYSLOG_MAXLEN at
+// most
+func readMsgLen(r *bufio.Reader) (int, error) {
+	var digits []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' && len(digits) > 0 {
+			break
+		}
+		digits = append(digits, c)
+		if c < '0' || c > '9' || len(digits) > len(strconv.Itoa(SYSLOG_MAXLEN)) {
+			return 0, fmt.Errorf("invalid message length: %q", digits)
+		}
+	}
+	n, err := strconv.Atoi(string(digits))
+	if err != nil || n > SYSLOG_MAXLEN {
+		return 0, fmt.Errorf("invalid message length: %q", digits)
+	}
+	return n, nil
+}
+
+// reads messages from conn until EOF
+func (s *SyslogSource) serve(conn net.Conn, ingest func(string, int64)) error {
+	r := bufio.NewReaderSize(conn, SYSLOG_MAXLEN)
+	for {
+		c, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var msg []byte
+		if c[0] >= '1' && c[0] <= '9' { // octet counting, MSG-LEN SP SYSLOG-MSG
+			n, err := readMsgLen(r)
+			if err != nil {
+				return err
+			}
+			msg = make([]byte, n)
+			if _, err = io.ReadFull(r, msg); err != nil {
+				return err
+			}
+		} else {
+			msg, err = r.ReadBytes('\n')
+			if err == io.EOF && len(msg) == 0 {
+				return nil
+			} else if err != nil && err != io.EOF {
+				return err
+			}
+		}
+		if len(bytes.TrimSpace(msg)) > 0 {
+			s.receive(msg, conn.RemoteAddr(), ingest)
+		}
+	}
+}
+
+// Close stops listening and closes connections.
+func (s *SyslogSource) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return s.listener.Close()
+}
@@ -0,0 +1,178 @@
+package lotf
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	ts := time.Date(2026, 3, 4, 5, 6, 7, 0, time.Local)
+	stamp := ts.Format(time.Stamp)
+	for _, c := range []struct {
+		msg    string
+		expect SyslogMessage
+		text   string
+	}{
+		{"<34>1 " + ts.Format(time.RFC3339) + " host su 123 ID47 - \xef\xbb\xbf'su root' failed\n",
+			SyslogMessage{Facility: 4, Severity: 2, Hostname: "host", AppName: "su", ProcID: "123", MsgID: "ID47", Message: "'su root' failed"},
+			stamp + " host su[123]: 'su root' failed"},
+		{`<165>1 ` + ts.Format(time.RFC3339) + ` host app - - [ex@1 a="x\]y" b="z"][ex@2] hello`,
+			SyslogMessage{Facility: 20, Severity: 5, Hostname: "host", AppName: "app", StructuredData: `[ex@1 a="x\]y" b="z"][ex@2]`, Message: "hello"},
+			stamp + ` host app: [ex@1 a="x\]y" b="z"][ex@2] hello`},
+		{"<13>" + stamp + " myhost sshd[42]: Accepted publickey",
+			SyslogMessage{Facility: 1, Severity: 5, Hostname: "myhost", AppName: "sshd", ProcID: "42", Message: "Accepted publickey"},
+			stamp + " myhost sshd[42]: Accepted publickey"},
+		{"<0>" + stamp + " myhost kernel: panic",
+			SyslogMessage{Facility: 0, Severity: 0, Hostname: "myhost", AppName: "kernel", Message: "panic"},
+			stamp + " myhost kernel: panic"},
+	} {
+		m := ParseSyslog([]byte(c.msg))
+		if !m.Timestamp.Equal(ts) {
+			t.Fatalf("expect timestamp %s, but got: %s", ts, m.Timestamp)
+		}
+		c.expect.Timestamp = m.Timestamp
+		if *m != c.expect {
+			t.Fatalf("expect %+v, but got: %+v", c.expect, *m)
+		}
+		if m.String() != c.text {
+			t.Fatalf("expect %q, but got: %q", c.text, m.String())
+		}
+	}
+
+	// no PRI nor timestamp
+	m := ParseSyslog([]byte("just a message"))
+	if m.Facility != 1 || m.Severity != 5 || m.Message != "just a message" || len(m.Hostname) != 0 {
+		t.Fatalf("unexpected message: %+v", *m)
+	}
+}
+
+func TestSyslogSource(t *testing.T) {
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	for _, network := range []string{"udp", "tcp"} {
+		src, err := NewSyslogSource(network + "://127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("NewSyslogSource failed: %s", err)
+		}
+		tail, err := tw.AddSource(src, 8, nil)
+		if err != nil {
+			t.Fatalf("AddSource failed: %s", err)
+		}
+		conn, err := net.Dial(network, src.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %s", err)
+		}
+		msgs := []string{"<13>1 - h1 app - - - first", "<13>Jan  1 00:00:00 h2 tag: second"}
+		if network == "udp" {
+			for _, msg := range msgs {
+				conn.Write([]byte(msg))
+			}
+		} else { // octet counting and NL framing
+			fmt.Fprintf(conn, "%d %s%s\n", len(msgs[0]), msgs[0], msgs[1])
+		}
+		for _, s := range []string{"h1 app: first", "h2 tag: second"} {
+			line := tail.WaitNextLine()
+			if line == nil || line.Text[len(time.Stamp)+1:] != s {
+				t.Fatalf("expect %s over %s, but got: %v", s, network, line)
+			}
+		}
+		conn.Close()
+
+		if err := tw.Remove(src.Name()); err != nil {
+			t.Fatalf("Remove failed: %s", err)
+		}
+		if line := tail.WaitNextLine(); line != nil {
+			t.Fatalf("expect end of lines, but got: %s", line)
+		}
+	}
+}
+
+// slowFilter tells a line is being filtered, and passes it after a while
+type slowFilter chan bool
+
+func (f slowFilter) Filter(line string) bool {
+	select {
+	case f <- true:
+	default:
+	}
+	time.Sleep(200 * time.Millisecond)
+	return true
+}
+
+func (f slowFilter) Reload() error { return nil }
+
+func TestSyslogSourceClose(t *testing.T) {
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+	src, err := NewSyslogSource("udp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewSyslogSource failed: %s", err)
+	}
+	filtering := make(slowFilter, 1)
+	if _, err := tw.AddSource(src, 8, filtering); err != nil {
+		t.Fatalf("AddSource failed: %s", err)
+	}
+	conn, err := net.Dial("udp", src.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>1 - h1 app - - - first"))
+	select {
+	case <-filtering:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no line received")
+	}
+
+	// closes while the line is ingested
+	closed := make(chan error)
+	go func() { closed <- tw.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Close does not return while ingesting")
+	}
+}
+
+func TestReadMsgLen(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		expect int // -1 for error
+	}{
+		{"12 x", 12},
+		{"65536 x", 65536},
+		{"65537 x", -1},
+		{"123456 x", -1},
+		{"1x x", -1},
+		{"99999999999999999999", -1}, // not read to the end
+		{"12", -1},
+	} {
+		n, err := readMsgLen(bufio.NewReader(strings.NewReader(c.in)))
+		if c.expect < 0 && err == nil || c.expect >= 0 && (err != nil || n != c.expect) {
+			t.Fatalf("in: %q, expect: %d, but got: %d, %v", c.in, c.expect, n, err)
+		}
+	}
+}
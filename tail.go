@@ -3,6 +3,7 @@ package lotf
 import (
 	"bufio"
 	"bytes"
+	"container/list"
 	"fmt"
 	inotify "github.com/chamaken/inotify"
 	"github.com/golang/glog"
@@ -12,11 +13,14 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 const (
 	BUFSIZ       = 8192
-	INOTIFY_MASK = inotify.IN_DELETE_SELF | inotify.IN_MOVE_SELF | inotify.IN_CREATE | inotify.IN_MOVE | inotify.IN_DELETE | inotify.IN_MODIFY
+	INOTIFY_MASK = inotify.IN_DELETE_SELF | inotify.IN_MOVE_SELF | inotify.IN_CREATE | inotify.IN_MOVE | inotify.IN_DELETE | inotify.IN_MODIFY | inotify.IN_CLOSE_WRITE
+	// for the file itself in FOLLOW_DESCRIPTOR
+	FILE_INOTIFY_MASK = inotify.IN_MODIFY | inotify.IN_ATTRIB | inotify.IN_DELETE_SELF
 )
 
 // tail -- output the last part of file(s)
@@ -123,22 +127,222 @@ LOOP:
 	return file.Seek(pos+int64(nlPos+1), os.SEEK_SET)
 }
 
+// stores last lines which filter accepts to q by reading file backward, and
+// returns the offset after the last NL.
+func lastLines(file *os.File, lines int, q *Blockq, filter Filter) (int64, error) {
+	return walkBackward(file, func(text string, offset int64) bool {
+		if lines <= 0 {
+			return false
+		}
+		if pass, m := matchLine(filter, text); pass {
+			q.AddHead(&Line{Text: text, Offset: offset, Match: m})
+			lines--
+		}
+		return lines > 0
+	})
+}
+
+// calls fn for lines from the last NL backward until it returns false or the
+// head of file, and returns the offset after the last NL.
+func walkBackward(file *os.File, fn func(text string, offset int64) bool) (int64, error) {
+	var pos int64
+	var line, lastLine []byte
+
+	// create TailReader and adjust to last NL
+	tr, err := NewTailReader(file)
+	if err == ErrorEmpty {
+		return pos, nil
+	} else if err != nil {
+		return -1, err
+	}
+	pos = tr.Tell()
+	lastLine, err = tr.PrevBytes('\n')
+	if err == ErrorStartOfFile {
+		return pos, nil
+	} else if err != nil {
+		return -1, err
+	} else if len(lastLine) != 1 { // not ended with '\n'
+		pos -= int64(len(lastLine) - 1)
+	}
+
+	for {
+		line, err = tr.PrevBytes('\n')
+		head := false
+		if err != nil {
+			if err != ErrorStartOfFile {
+				if glog.V(1) {
+					glog.Infof("TailReader.PrevBytes(): %s", err)
+				}
+				return -1, err
+			}
+			head = true
+		}
+		offset := int64(0)
+		if len(line) > 0 && line[0] == '\n' {
+			line = line[1:]
+			offset = tr.Tell() + 1
+		}
+		if !fn(string(line), offset) || head {
+			return pos, nil
+		}
+	}
+}
+
 type TailName struct {
-	name    string   // file absname
-	file    *os.File // watching file
-	lastp   int64    // file position last newline after 1
-	lines   *Blockq  // stores lines with no NL
-	filter  Filter   // lines is not store if this returns false
+	name    string        // file absname
+	file    *os.File      // watching file
+	lastp   int64         // file position last newline after 1
+	ino     uint64        // inode of the file, kept after the file disappeared
+	dev     uint64        // device of the file, to check identity on reopen
+	idle    bool          // file was closed by SetMaxOpen, not disappeared
+	lru     *list.Element // in TailWatcher.lru while the file is opened
+	lines   *Blockq       // stores lines with no NL
+	filter  Filter        // lines is not store if this returns false
+	mapper  Mapper        // rewrites lines filter accepted
+	raw     *rawLines     // lines before filter for Refilter, nil if not kept
+	dedup   *Dedup        // collapses repeated lines filter accepted
+	context *contextLines // selects lines around the ones filter accepted
+	hooks   *hookList     // lifecycle event subscribers
+	markers bool          // stores Marker on lifecycle events
+	rotated bool          // History reads rotated siblings too
+	view    Filter        // applied on reading, lines are skipped if this returns false
+	views   *viewList     // view filters counted on ingest
+	stream  *stream       // not nil if added by AddReader or AddFile
+	follow  FollowMode
+	paused  bool   // events are recorded in pending, not handled
+	pending uint32 // inotify event mask while paused
+	params  string // parameters of the first Add, see ingestKey
+	refs    int    // Add and views returned, Remove stops at the last one
 	current *Element
 }
 
+// StartFrom tells where TailWatcher.AddOptions starts with, see TailOptions.
+type StartFrom int
+
+const (
+	FROM_LAST_LINES StartFrom = iota // last lines of Add, the default
+	FROM_LINE                        // from line Start, 1 origin like tail -n +K
+	FROM_OFFSET                      // from byte offset Start, 0 origin
+	FROM_LAST_BYTES                  // from last Start bytes like tail -c N
+)
+
+// FollowMode tells what TailName follows, like tail --follow.
+type FollowMode int
+
+const (
+	FOLLOW_NAME       FollowMode = iota // reopens the name after rotation, the default
+	FOLLOW_DESCRIPTOR                   // keeps the opened file wherever it is moved
+)
+
+// TailOptions is optional parameters for TailWatcher.AddOptions. The zero
+// value is the same as TailWatcher.Add.
+type TailOptions struct {
+	Markers        bool      // stores Marker in lines on lifecycle events except error
+	RotatedHistory bool      // History continues to rotated siblings, name.1, name.2...
+	Since          time.Time // starts from the first line at or after this, not last lines
+	TimeLayouts    []string  // to parse the line head timestamp, DefaultTimeLayouts if nil
+	From           StartFrom // ignored if Since is specified
+	Start          int64     // line number or byte offset for From
+	Follow         FollowMode
+	Mapper         Mapper // rewrites lines after filtering, stored ones too
+	RawLines       int    // keeps this number of lines before filtering for Refilter
+	Dedup          *Dedup // collapses repeats after filtering, not in the lines to start with
+	Before         int    // stores lines before the ones filter accepts like grep -B
+	After          int    // stores lines after the ones filter accepts like grep -A
+}
+
 type Tail interface {
 	Name() string
 	WaitNext() *string
 	Next() *string
+	WaitNextLine() *Line
+	NextLine() *Line
 	Reset()
 	Clone() Tail
 	SetFilter(Filter)
+	SetMapper(Mapper)
+	SetView(Filter)
+	Refilter() error
+	History(before int64, n int, filter Filter) ([]*Line, error)
+}
+
+func fileIno(fi os.FileInfo) uint64 {
+	return fi.Sys().(*syscall.Stat_t).Ino
+}
+
+func fileDev(fi os.FileInfo) uint64 {
+	return uint64(fi.Sys().(*syscall.Stat_t).Dev)
+}
+
+// notifies lifecycle event to subscribers and readers if required
+func (tail *TailName) emit(ev *TailEvent) {
+	ev.Name = tail.name
+	ev.Time = time.Now()
+	if ev.Type != TAIL_ERROR {
+		tail.storeEvent(ev)
+	}
+	tail.hooks.emit(ev)
+}
+
+// ends the run of dedup and stores the marker of ev if required
+func (tail *TailName) storeEvent(ev *TailEvent) {
+	if tail.raw != nil {
+		tail.raw.mu.Lock()
+		defer tail.raw.mu.Unlock()
+	}
+	if tail.dedup != nil {
+		if summary := tail.dedup.flush(); len(summary) > 0 {
+			tail.lines.Add(&Line{Text: summary, Offset: ev.OldOffset})
+		}
+	}
+	if tail.context != nil {
+		tail.context.skip(1)
+	}
+	if tail.markers {
+		line := &Line{Marker: &Marker{Type: MARKER_EVENT, Event: ev}}
+		if tail.raw != nil {
+			tail.raw.lines.Add(line)
+		}
+		tail.lines.Add(line)
+	}
+}
+
+// stores a line which starts at offset if filter accepts it
+func (tail *TailName) ingest(text string, offset int64) {
+	if tail.raw != nil {
+		tail.raw.mu.Lock()
+		defer tail.raw.mu.Unlock()
+		tail.raw.lines.Add(&Line{Text: text, Offset: offset})
+	}
+	match, m := matchLine(tail.filter, text)
+	if match {
+		tail.views.count(text)
+	}
+	line := &Line{Text: text, Offset: offset, Match: m}
+	if tail.context != nil {
+		tail.context.feed(tail.lines, tail.dedup, tail.mapper, line, match)
+	} else if match {
+		storeLine(tail.lines, tail.dedup, tail.mapper, line)
+	}
+}
+
+// sends err to errch as TailError after notifying it to subscribers
+func (tail *TailName) sendError(errch chan<- error, op string, severity Severity, err error) {
+	err = &TailError{
+		Path:     tail.name,
+		Op:       op,
+		Severity: severity,
+		Err:      err,
+	}
+	tail.emit(&TailEvent{
+		Type:      TAIL_ERROR,
+		OldIno:    tail.ino,
+		NewIno:    tail.ino,
+		OldOffset: tail.lastp,
+		NewOffset: tail.lastp,
+		Err:       err,
+	})
+	errch <- err
 }
 
 // subroutine of event handlers. This function reads lines from tail.lastp
@@ -150,7 +354,7 @@ func (tail *TailName) readlines(errch chan<- error) {
 
 	if _, err = tail.file.Seek(tail.lastp, os.SEEK_SET); err != nil {
 		glog.Infof("File.Seek(%d, SEEK_SET): %s", tail.lastp, err)
-		errch <- err
+		tail.sendError(errch, "seek", SEVERITY_ERROR, err)
 		return
 	}
 	r := bufio.NewReader(tail.file)
@@ -160,68 +364,95 @@ func (tail *TailName) readlines(errch chan<- error) {
 			return
 		} else if err != nil {
 			glog.Infof("File.ReadBytes(): %s", err)
-			errch <- err
+			tail.sendError(errch, "read", SEVERITY_ERROR, err)
 			return
 		}
-		if tail.filter == nil || tail.filter.Filter(string(line[:len(line)-1])) {
-			tail.lines.Add(string(line[:len(line)-1]))
-		}
+		tail.ingest(string(line[:len(line)-1]), tail.lastp)
 		tail.lastp += int64(len(line))
 	}
 }
 
-// IN_CREATE event handler. This function opens file named tail.name and reads lines.
-// tail.file should be nil if this function is called.
+// IN_CREATE or IN_MOVED_TO event handler. This function opens file named
+// tail.name and reads lines. tail.file should be nil if this function is called.
 func (tail *TailName) handleCreate(errch chan<- error) {
 	var err error
 
 	if tail.file != nil {
-		errch <- fmt.Errorf("open already opened file")
+		tail.sendError(errch, "open", SEVERITY_WARNING, ErrorAlreadyOpened)
 		if err := tail.file.Close(); err != nil {
 			glog.Infof("File.Close(): %s", err)
-			errch <- err
+			tail.sendError(errch, "close", SEVERITY_WARNING, err)
 		}
 	}
 
 	tail.file, err = os.Open(tail.name)
 	if err != nil {
 		glog.Infof("File.Open(%s): %s", tail.name, err)
-		errch <- err
+		tail.sendError(errch, "open", SEVERITY_ERROR, err)
+		return
+	}
+	fi, err := tail.file.Stat()
+	if err != nil {
+		glog.Infof("File.Stat(): %s", err)
+		tail.sendError(errch, "stat", SEVERITY_ERROR, err)
 		return
 	}
+
+	oldIno, oldOffset := tail.ino, tail.lastp
+	tail.ino = fileIno(fi)
+	tail.dev = fileDev(fi)
+	tail.idle = false
 	tail.lastp = 0
+	tail.emit(&TailEvent{Type: TAIL_CREATE, NewIno: tail.ino})
+	if oldIno != 0 && oldIno != tail.ino {
+		tail.emit(&TailEvent{
+			Type:      TAIL_ROTATE,
+			OldIno:    oldIno,
+			NewIno:    tail.ino,
+			OldOffset: oldOffset,
+		})
+	}
 	tail.readlines(errch)
 }
 
-// IN_DELETE or IN_MOVED event handler. tail.tailp will differ is last modification
-// was not ended with newline so that the last line will store only in the case.
-// This function close tail.file and invalidate it after that.
+// IN_DELETE or IN_MOVED_FROM event handler. tail.tailp will differ is last
+// modification was not ended with newline so that the last line will store only
+// in the case. This function close tail.file and invalidate it after that.
 func (tail *TailName) handleDisappear(errch chan<- error) {
+	if tail.file == nil {
+		if !tail.idle {
+			return
+		}
+		// closed by SetMaxOpen, drain where it was renamed to
+		tail.idle = false
+		if tail.file = tail.openMoved(); tail.file == nil { // deleted, unread lines are lost
+			tail.emit(&TailEvent{Type: TAIL_DELETE, OldIno: tail.ino, OldOffset: tail.lastp})
+			return
+		}
+	}
 	fi, err := tail.file.Stat()
 	if err != nil {
 		glog.Infof("File.Stat(): %s", err)
-		errch <- err
+		tail.sendError(errch, "stat", SEVERITY_ERROR, err)
 		return
 	}
 	// read unfinished one line
 	for fi.Size() > tail.lastp {
 		if _, err = tail.file.Seek(tail.lastp, os.SEEK_SET); err != nil {
 			glog.Infof("File.Seek(%d, SEEK_SET): %s", tail.lastp, err)
-			errch <- err
+			tail.sendError(errch, "seek", SEVERITY_ERROR, err)
 		}
 		r := bufio.NewReader(tail.file)
 		line, err := r.ReadBytes(byte('\n'))
 		// add line even if it does not end with LF
 		if err != nil && err != io.EOF {
 			glog.Infof("File.ReadBytes(): %s", err)
-			errch <- err
+			tail.sendError(errch, "read", SEVERITY_ERROR, err)
 		}
-		if tail.filter == nil || tail.filter.Filter(string(line[:len(line)-1])) {
-			if line[len(line)-1] == byte('\n') {
-				tail.lines.Add(string(line[:len(line)-1]))
-			} else {
-				tail.lines.Add(string(line))
-			}
+		if line[len(line)-1] == byte('\n') {
+			tail.ingest(string(line[:len(line)-1]), tail.lastp)
+		} else {
+			tail.ingest(string(line), tail.lastp)
 		}
 		tail.lastp += int64(len(line))
 	}
@@ -229,49 +460,151 @@ func (tail *TailName) handleDisappear(errch chan<- error) {
 	// close and invalidate TailName.file
 	if err = tail.file.Close(); err != nil {
 		glog.Infof("File.Close(): %s", err)
-		errch <- err
+		tail.sendError(errch, "close", SEVERITY_WARNING, err)
 	}
 	tail.file = nil
+	tail.emit(&TailEvent{Type: TAIL_DELETE, OldIno: tail.ino, OldOffset: tail.lastp})
 }
 
 // IN_MODIFY event handler. This function checks file size and store lines if the file
-// was grown up.
+// was grown up, or reads from the beginning if it was truncated.
 func (tail *TailName) handleModify(errch chan<- error) {
+	if tail.file == nil {
+		return
+	}
 	fi, err := tail.file.Stat()
 	if err != nil {
 		glog.Infof("File.Stat(): %s", err)
-		errch <- err
+		tail.sendError(errch, "stat", SEVERITY_ERROR, err)
 		return
 	}
 	if fi.Size() > tail.lastp {
 		tail.readlines(errch)
+	} else if fi.Size() < tail.lastp {
+		tail.emit(&TailEvent{
+			Type:      TAIL_TRUNCATE,
+			OldIno:    tail.ino,
+			NewIno:    tail.ino,
+			OldOffset: tail.lastp,
+		})
+		tail.lastp = 0
+		tail.readlines(errch)
 	}
-	// XXX: else if fi.Size() < tail.lastp { reread from first? }
 }
 
 func (tail *TailName) Name() string {
 	return tail.name
 }
 
+// WaitNext returns the next line, skipping markers.
 func (tail *TailName) WaitNext() *string {
-	next := tail.current.WaitNext()
-	if next == nil { // TailWatcher has closed
-		// XXX: what should do after Remove()
-		return nil
+	for {
+		line := tail.WaitNextLine()
+		if line == nil {
+			return nil
+		}
+		if line.Marker == nil {
+			s := line.Text
+			return &s
+		}
 	}
-	tail.current = next
-	s := tail.current.Value.(string)
-	return &s
 }
 
+// Next returns the next line without blocking, skipping markers.
 func (tail *TailName) Next() *string {
-	e := tail.current.Next()
-	if e == nil {
+	for {
+		line := tail.NextLine()
+		if line == nil {
+			return nil
+		}
+		if line.Marker == nil {
+			s := line.Text
+			return &s
+		}
+	}
+}
+
+// viewList is the view filters set on a TailName and its clones, which count
+// lines when they are ingested, not each time a clone reads them.
+type viewList struct {
+	mu    sync.Mutex
+	views []Filter
+}
+
+// adds filter if it counts stats and is not added yet
+func (vl *viewList) add(filter Filter) {
+	if _, ok := filter.(evaluator); !ok || vl == nil {
+		return
+	}
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+	for _, v := range vl.views {
+		if v == filter {
+			return
+		}
+	}
+	vl.views = append(vl.views, filter)
+}
+
+// counts text as ingested by the view filters
+func (vl *viewList) count(text string) {
+	if vl == nil {
+		return
+	}
+	vl.mu.Lock()
+	views := make([]Filter, len(vl.views))
+	copy(views, vl.views)
+	vl.mu.Unlock()
+
+	for _, v := range views {
+		evalFilter(v, text, STATS_TOP)
+	}
+}
+
+// returns line seen through the view filter, nil if it should be skipped. A
+// copy with the match is returned if the view is a Matcher, since line is
+// shared. The view filter counts nothing here, see viewList.
+func (tail *TailName) viewed(line *Line) *Line {
+	if line.Marker != nil || tail.view == nil {
+		return line
+	}
+	pass, m := evalMatch(tail.view, line.Text, STATS_NONE)
+	switch {
+	case !pass:
 		return nil
+	case m != nil:
+		return &Line{Text: line.Text, Offset: line.Offset, Match: m}
+	}
+	return line
+}
+
+// WaitNextLine returns the next line or marker.
+func (tail *TailName) WaitNextLine() *Line {
+	for {
+		next := tail.current.WaitNext()
+		if next == nil { // TailWatcher has closed
+			// XXX: what should do after Remove()
+			return nil
+		}
+		tail.current = next
+		if line := tail.viewed(next.Value.(*Line)); line != nil {
+			return line
+		}
+	}
+}
+
+// NextLine returns the next line or marker without blocking.
+func (tail *TailName) NextLine() *Line {
+	for {
+		e := tail.current.Next()
+		if e == nil {
+			return nil
+		}
+		tail.current = e
+		if line := tail.viewed(e.Value.(*Line)); line != nil {
+			return line
+		}
 	}
-	tail.current = e
-	s := e.Value.(string)
-	return &s
 }
 
 func (tail *TailName) Reset() {
@@ -283,8 +616,20 @@ func (tail *TailName) Clone() Tail {
 		name:    tail.name,
 		file:    tail.file,
 		lastp:   tail.lastp,
+		ino:     tail.ino,
 		lines:   tail.lines,
 		filter:  tail.filter,
+		mapper:  tail.mapper,
+		raw:     tail.raw,
+		dedup:   tail.dedup,
+		context: tail.context,
+		hooks:   tail.hooks,
+		markers: tail.markers,
+		rotated: tail.rotated,
+		view:    tail.view,
+		views:   tail.views,
+		stream:  tail.stream,
+		follow:  tail.follow,
 		current: tail.lines.head,
 	}
 }
@@ -293,20 +638,45 @@ func (tail *TailName) SetFilter(filter Filter) {
 	tail.filter = filter
 }
 
+// SetMapper sets the mapper which rewrites lines ingested after this.
+func (tail *TailName) SetMapper(mapper Mapper) {
+	tail.mapper = mapper
+}
+
+// SetView sets the filter which is applied lazily on reading. Unlike
+// SetFilter, this affects only the receiver, not its clones. The filter counts
+// lines ingested after this once, however many clones read them.
+func (tail *TailName) SetView(filter Filter) {
+	tail.view = filter
+	tail.views.add(filter)
+}
+
 func (tail *TailName) String() string {
+	s := tail.name
 	if tail.filter != nil {
-		return fmt.Sprintf("%s | %s", tail.name, tail.filter)
+		s = fmt.Sprintf("%s | %s", s, tail.filter)
 	}
-	return tail.name
+	if tail.view != nil {
+		s = fmt.Sprintf("%s | %s", s, tail.view)
+	}
+	return s
 }
 
 type TailWatcher struct {
-	watch  *inotify.Watcher
-	tails  map[string]*TailName // key: abs pathname or parent dirname if TailName is nil
-	dirs   map[string]int       // key: dirname, value: refcount
-	mu     sync.Mutex           // to sync tails map
-	Error  <-chan error
-	closed bool
+	watch   *inotify.Watcher
+	tails   map[string]*TailName  // key: abs pathname or parent dirname if TailName is nil
+	dirs    map[string]int        // key: dirname, value: refcount
+	streams map[string]*TailName  // key: name of AddReader or AddFile
+	reloads map[string][]Reloader // key: abs pathname of the source, see WatchReload
+	mu      sync.Mutex            // to sync tails map
+	errch   chan error            // TailError from handlers and inotify
+	lru     *list.List            // TailName opened, recently modified first
+	resumed []*TailName           // to catch up in follow
+	wake    chan bool             // notifies resumed to follow
+	maxopen int                   // SetMaxOpen
+	wg      sync.WaitGroup        // senders to errch
+	Error   <-chan error
+	closed  bool
 }
 
 // TailWatcher constructor
@@ -319,49 +689,114 @@ func NewTailWatcher() (*TailWatcher, error) {
 		return nil, err
 	}
 
+	errch := make(chan error)
 	tw := &TailWatcher{
-		watcher,
-		make(map[string]*TailName),
-		make(map[string]int),
-		*new(sync.Mutex),
-		watcher.Error,
-		false,
-	}
-	go tw.follow()
+		watch:   watcher,
+		tails:   make(map[string]*TailName),
+		dirs:    make(map[string]int),
+		streams: make(map[string]*TailName),
+		reloads: make(map[string][]Reloader),
+		lru:     list.New(),
+		wake:    make(chan bool, 1),
+		errch:   errch,
+		Error:   errch,
+	}
+
+	// close Error after all senders finished, including stream readers
+	tw.wg.Add(2)
+	go func() {
+		tw.follow()
+		tw.wg.Done()
+	}()
+	go func() {
+		tw.forwardError()
+		tw.wg.Done()
+	}()
+	go func() {
+		tw.wg.Wait()
+		close(errch)
+	}()
 	return tw, nil
 }
 
+// annotates inotify errors, which TailWatcher can not recover from
+func (tw *TailWatcher) forwardError() {
+	for err := range tw.watch.Error {
+		tw.errch <- &TailError{Op: "inotify", Severity: SEVERITY_FATAL, Err: err}
+	}
+}
+
 // Watcher event dispatcher
 func (tw *TailWatcher) follow() {
-	for ev := range tw.watch.Event {
-		// need Lock?
-		tail, found := tw.tails[ev.Name]
-		if !found {
-			continue
+	for {
+		select {
+		case ev, ok := <-tw.watch.Event:
+			if !ok {
+				return
+			}
+			tw.dispatch(ev)
+		case <-tw.wake:
+			tw.catchup()
 		}
-		switch {
-		case ev.Mask&inotify.IN_CREATE != 0:
-			tail.handleCreate(tw.watch.Error)
-		case ev.Mask&(inotify.IN_DELETE|inotify.IN_MOVE) != 0:
-			tail.handleDisappear(tw.watch.Error)
-		case ev.Mask&inotify.IN_MODIFY != 0:
-			tail.handleModify(tw.watch.Error)
-		case ev.Mask&(inotify.IN_DELETE_SELF|inotify.IN_MOVE_SELF) != 0:
-			tw.handleParentDisappear(ev.Name, tw.watch.Error)
+	}
+}
+
+// calls the event handler of TailName
+func (tw *TailWatcher) dispatch(ev *inotify.Event) {
+	if ev.Mask&inotify.IN_Q_OVERFLOW != 0 {
+		tw.errch <- &TailError{Op: "inotify", Severity: SEVERITY_ERROR, Err: ErrorQueueOverflow}
+		return
+	}
+	if ev.Mask&(inotify.IN_CLOSE_WRITE|inotify.IN_MOVED_TO) != 0 {
+		tw.reload(ev.Name)
+	}
+	if ev.Mask&^inotify.IN_CLOSE_WRITE == 0 { // only for reload
+		return
+	}
+	tw.mu.Lock()
+	tail, found := tw.tails[ev.Name]
+	tw.mu.Unlock()
+	if !found {
+		return
+	}
+	if tail != nil && tw.record(tail, ev.Mask) {
+		return
+	}
+	if tail != nil && tail.follow == FOLLOW_DESCRIPTOR {
+		tw.handleDescriptor(tail, ev.Mask)
+		return
+	}
+	switch {
+	case ev.Mask&(inotify.IN_CREATE|inotify.IN_MOVED_TO) != 0:
+		if ev.Mask&inotify.IN_MOVED_TO != 0 {
+			// replaced by rename(2), no IN_DELETE for the old one
+			tail.handleDisappear(tw.errch)
 		}
+		tail.handleCreate(tw.errch)
+	case ev.Mask&(inotify.IN_DELETE|inotify.IN_MOVED_FROM) != 0:
+		tail.handleDisappear(tw.errch)
+	case ev.Mask&inotify.IN_MODIFY != 0:
+		if tail.idle {
+			tail.reopen(tw.errch)
+		}
+		tail.handleModify(tw.errch)
+	case ev.Mask&(inotify.IN_DELETE_SELF|inotify.IN_MOVE_SELF) != 0:
+		tw.handleParentDisappear(ev.Name, tw.errch)
+	}
+	if tail != nil {
+		tw.track(tail)
 	}
 }
 
 func (tw *TailWatcher) Close() error {
 	if tw.closed {
-		return os.NewSyscallError("closed", syscall.EBADF)
+		return &TailError{Op: "close", Severity: SEVERITY_ERROR, Err: ErrorClosed}
 	}
 	if err := tw.watch.Close(); err != nil {
 		return err
 	}
 
 	tw.mu.Lock()
-	defer tw.mu.Unlock()
 	for _, tail := range tw.tails {
 		if tail == nil { // parent directory
 			continue
@@ -374,30 +809,96 @@ func (tw *TailWatcher) Close() error {
 			if glog.V(1) {
 				glog.Infof("File.Close(): %s", err)
 			}
+			tw.mu.Unlock()
 			return err
 		}
 	}
+	streams := tw.streams
 	tw.tails = nil
 	tw.dirs = nil
+	tw.streams = nil
+	tw.reloads = nil
 	tw.closed = true
+	tw.mu.Unlock()
 
+	// sources may be ingesting, which takes tw.mu
+	for _, tail := range streams {
+		tail.lines.Done()
+		tail.stream.stop()
+	}
 	return nil
 }
 
+// Add starts watching pathname and returns its Tail. If pathname is already
+// watched, this returns a view on it instead, which shares the lines ingested
+// once. The view applies filter on reading if the first Add had no filter, or
+// has no filter of its own if filter is the same, String of them are equal.
+// Other filter, maxline, lines or options than the first Add's can not be
+// applied to the lines ingested, and ErrorIngestDiffers is returned then.
+// Remove stops watching at the last Remove of the ones added.
 func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines int) (Tail, error) {
+	return tw.AddOptions(pathname, maxline, filter, lines, nil)
+}
+
+// returns a string telling the parameters of lines ingested except filter
+func ingestKey(maxline, lines int, opts *TailOptions) string {
+	return fmt.Sprintf("%d %d %t %t %v %v %d %d %d %v %d %v %d %d", maxline, lines,
+		opts.Markers, opts.RotatedHistory, opts.Since, opts.TimeLayouts, opts.From, opts.Start,
+		opts.Follow, opts.Mapper, opts.RawLines, opts.Dedup, opts.Before, opts.After)
+}
+
+// returns a view of tail already added for the second Add of filter and
+// ingest, caller must hold tw.mu
+func viewOf(tail *TailName, filter Filter, ingest string) (Tail, error) {
+	if ingest != tail.params {
+		return nil, &TailError{Path: tail.name, Op: "add", Severity: SEVERITY_ERROR, Err: ErrorIngestDiffers}
+	}
+	view := tail.Clone()
+	switch {
+	case tail.filter == nil:
+		view.SetView(filter)
+	case filter == nil || filterString(filter) != filterString(tail.filter):
+		return nil, &TailError{Path: tail.name, Op: "add", Severity: SEVERITY_ERROR, Err: ErrorIngestDiffers}
+	}
+	tail.refs++
+	return view, nil
+}
+
+// returns a view of already watching absname, caller must hold tw.mu
+func (tw *TailWatcher) view(absname string, filter Filter, ingest string) (Tail, error) {
+	tail, found := tw.tails[absname]
+	if !found {
+		return nil, nil
+	}
+	if tail == nil { // parent directory
+		return nil, &TailError{Path: absname, Op: "add", Severity: SEVERITY_ERROR, Err: ErrorAlreadyWatching}
+	}
+	return viewOf(tail, filter, ingest)
+}
+
+// AddOptions is the same as Add but takes optional parameters. opts may be nil.
+// lines is ignored if opts tells another start position, Since or From, and
+// lines from there are stored to at most maxline, older ones are dropped.
+func (tw *TailWatcher) AddOptions(pathname string, maxline int, filter Filter, lines int, opts *TailOptions) (Tail, error) {
 	if tw.closed {
-		return nil, os.NewSyscallError("closed", syscall.EBADF)
+		return nil, &TailError{Path: pathname, Op: "add", Severity: SEVERITY_ERROR, Err: ErrorClosed}
+	}
+	if opts == nil {
+		opts = &TailOptions{}
 	}
+	ingest := ingestKey(maxline, lines, opts)
 
 	var tail *TailName
-	var absname string // TailName.name
-	var dirname string // watch dir name
-	var file *os.File  // TailName.file
-	var pos int64      // TailName.lastp
-	var q *Blockq      // TailName.Lines
+	var absname string        // TailName.name
+	var dirname string        // watch dir name
+	var file *os.File         // TailName.file
+	var fi os.FileInfo        // TailName.ino
+	var pos int64             // TailName.lastp
+	var q *Blockq             // TailName.Lines
+	var start int64           // where q is filled from, negative for last lines
+	var raw *rawLines         // TailName.raw
+	var context *contextLines // TailName.context
 
-	var tr *TailReader
-	var line, lastLine []byte
 	var err error
 
 	// normalize pathname
@@ -407,8 +908,11 @@ func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines in
 		}
 		return nil, err
 	}
-	if _, found := tw.tails[absname]; found {
-		return nil, fmt.Errorf("already watching: %s", absname)
+	tw.mu.Lock()
+	view, err := tw.view(absname, filter, ingest)
+	tw.mu.Unlock()
+	if view != nil || err != nil {
+		return view, err
 	}
 	dirname = filepath.Dir(absname)
 
@@ -419,6 +923,12 @@ func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines in
 		}
 		return nil, err
 	}
+	if fi, err = file.Stat(); err != nil {
+		if glog.V(1) {
+			glog.Infof("File.Stat(): %s", err)
+		}
+		goto ERR_CLOSE
+	}
 
 	// create list for last lines
 	if q, err = NewBlockq(maxline); err != nil {
@@ -428,42 +938,31 @@ func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines in
 		goto ERR_CLOSE
 	}
 
-	// create TailReader and adjust to last NL
-	tr, err = NewTailReader(file)
-	if err == ErrorEmpty {
-		lines = 0
-	} else if err != nil {
-		goto ERR_CLOSE
-	} else {
-		pos = tr.Tell()
-		lastLine, err = tr.PrevBytes('\n')
-		if err == ErrorStartOfFile {
-			lines = 0
-		} else if err != nil {
-			goto ERR_CLOSE
-		} else if len(lastLine) != 1 { // not ended with '\n'
-			pos -= int64(len(lastLine) - 1)
+	// stores lines to start with
+	context = newContextLines(opts.Before, opts.After)
+	switch {
+	case !opts.Since.IsZero():
+		if start, err = SeekTime(file, opts.Since, opts.TimeLayouts); err == nil {
+			pos, err = fillLines(file, start, q, filter, context)
 		}
-	}
-
-	// stores last lines from TailReader
-	for lines > 0 {
-		line, err = tr.PrevBytes('\n')
-		if err != nil {
-			if err != ErrorStartOfFile {
-				if glog.V(1) {
-					glog.Infof("TailReader.PrevBytes(): %s", err)
-				}
-				goto ERR_CLOSE
-			}
-			lines = 0
+	case opts.From != FROM_LAST_LINES:
+		if start, err = startOffset(file, opts); err == nil {
+			pos, err = fillLines(file, start, q, filter, context)
 		}
-		if len(line) > 0 && line[0] == '\n' {
-			line = line[1:]
-		}
-		if filter == nil || filter.Filter(string(line)) {
-			q.AddHead(string(line))
-			lines--
+	case context != nil:
+		start = -1
+		pos, err = lastContextLines(file, lines, q, filter, context)
+	default:
+		start = -1
+		pos, err = lastLines(file, lines, q, filter)
+	}
+	if err != nil {
+		goto ERR_CLOSE
+	}
+	mapLines(q, opts.Mapper)
+	if opts.RawLines > 0 {
+		if raw, err = fillRaw(file, opts.RawLines, start); err != nil {
+			goto ERR_CLOSE
 		}
 	}
 
@@ -478,8 +977,21 @@ func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines in
 		name:    absname,
 		file:    file,
 		lastp:   pos,
+		ino:     fileIno(fi),
+		dev:     fileDev(fi),
 		lines:   q,
 		filter:  filter,
+		mapper:  opts.Mapper,
+		raw:     raw,
+		dedup:   opts.Dedup,
+		context: context,
+		hooks:   new(hookList),
+		views:   new(viewList),
+		markers: opts.Markers,
+		rotated: opts.RotatedHistory,
+		follow:  opts.Follow,
+		params:  ingest,
+		refs:    1,
 		current: q.head,
 	}
 
@@ -487,26 +999,28 @@ func (tw *TailWatcher) Add(pathname string, maxline int, filter Filter, lines in
 	defer tw.mu.Unlock()
 
 	// check again with holding lock
-	if _, found := tw.tails[absname]; found {
-		err = fmt.Errorf("already watching: %s", absname)
-		goto ERR_CLOSE
+	if view, err := tw.view(absname, filter, ingest); view != nil || err != nil {
+		file.Close()
+		return view, err
 	}
-	if refcnt, found := tw.dirs[dirname]; !found {
-		err = tw.watch.AddWatchFilter(dirname, INOTIFY_MASK,
-			func(e *inotify.Event) bool {
-				_, found := tw.tails[e.Name]
-				return found
-			})
-		if err != nil {
+	if opts.Follow == FOLLOW_DESCRIPTOR {
+		// watches the file itself instead of its directory
+		if err = tw.watch.AddWatch(absname, FILE_INOTIFY_MASK); err != nil {
 			if glog.V(1) {
-				glog.Infof("AddWatchFilter(): %s", err)
+				glog.Infof("AddWatch(): %s", err)
 			}
 			goto ERR_CLOSE
 		}
-		tw.dirs[dirname] = 1
-		tw.tails[dirname] = nil
+		tw.tails[absname] = tail
+		return tail, nil
+	}
+	if err = tw.watchDir(dirname); err != nil {
+		goto ERR_CLOSE
+	}
+	if tw.maxopen > 0 && tw.lru.Len() >= tw.maxopen {
+		tail.closeIdle() // starts closed over the cap
 	} else {
-		tw.dirs[dirname] = refcnt + 1
+		tail.lru = tw.lru.PushFront(tail)
 	}
 	tw.tails[absname] = tail
 
@@ -517,32 +1031,101 @@ ERR_CLOSE:
 	return nil, err
 }
 
-func (tw *TailWatcher) Lookup(pathname string) (Tail, error) {
+// watches dirname or increments its refcount, caller must hold tw.mu
+func (tw *TailWatcher) watchDir(dirname string) error {
+	if refcnt, found := tw.dirs[dirname]; found {
+		tw.dirs[dirname] = refcnt + 1
+		return nil
+	}
+	// called from the inotify reader, not under tw.mu held by the caller
+	err := tw.watch.AddWatchFilter(dirname, INOTIFY_MASK,
+		func(e *inotify.Event) bool {
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if _, found := tw.tails[e.Name]; found {
+				return true
+			}
+			_, found := tw.reloads[e.Name]
+			return found
+		})
+	if err != nil {
+		if glog.V(1) {
+			glog.Infof("AddWatchFilter(): %s", err)
+		}
+		return err
+	}
+	tw.dirs[dirname] = 1
+	tw.tails[dirname] = nil
+	return nil
+}
+
+// returns TailName itself, not a clone
+func (tw *TailWatcher) find(pathname string) (*TailName, error) {
 	if tw.closed {
-		return nil, os.NewSyscallError("closed", syscall.EBADF)
+		return nil, &TailError{Path: pathname, Op: "lookup", Severity: SEVERITY_ERROR, Err: ErrorClosed}
 	}
 
 	// normalize pathname
 	absname, err := filepath.Abs(pathname)
 	if err != nil {
 		if glog.V(1) {
-			glog.Info("filepath.Abs(): %s", err)
+			glog.Infof("filepath.Abs(): %s", err)
 		}
 		return nil, err
 	}
 
 	tw.mu.Lock()
 	defer tw.mu.Unlock()
+	if tail, found := tw.streams[pathname]; found {
+		return tail, nil
+	}
 	// tail == nil means parent directory
 	if tail, found := tw.tails[absname]; tail != nil && found {
-		return tail.Clone(), nil
+		return tail, nil
+	}
+	return nil, &TailError{Path: absname, Op: "lookup", Severity: SEVERITY_ERROR, Err: ErrorNotWatching}
+}
+
+func (tw *TailWatcher) Lookup(pathname string) (Tail, error) {
+	tail, err := tw.find(pathname)
+	if err != nil {
+		return nil, err
+	}
+	// streams update lastp under tw.mu
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tail.Clone(), nil
+}
+
+// Subscribe registers hooks which will be called on lifecycle events of the
+// watching pathname.
+func (tw *TailWatcher) Subscribe(pathname string, hooks *Hooks) error {
+	tail, err := tw.find(pathname)
+	if err != nil {
+		return err
+	}
+	tail.hooks.add(hooks)
+	return nil
+}
+
+// Unsubscribe unregisters hooks which was registered by Subscribe.
+func (tw *TailWatcher) Unsubscribe(pathname string, hooks *Hooks) error {
+	tail, err := tw.find(pathname)
+	if err != nil {
+		return err
 	}
-	return nil, fmt.Errorf("no such a watcher: %s", absname)
+	if !tail.hooks.remove(hooks) {
+		return fmt.Errorf("no such a subscription: %s", tail.name)
+	}
+	return nil
 }
 
 func (tw *TailWatcher) Remove(pathname string) error {
 	if tw.closed {
-		return os.NewSyscallError("closed", syscall.EBADF)
+		return &TailError{Path: pathname, Op: "remove", Severity: SEVERITY_ERROR, Err: ErrorClosed}
+	}
+	if tw.removeStream(pathname) {
+		return nil
 	}
 
 	// normalize pathname
@@ -559,8 +1142,16 @@ func (tw *TailWatcher) Remove(pathname string) error {
 	defer tw.mu.Unlock()
 	tail, found := tw.tails[absname]
 	if !found || tail == nil {
-		return fmt.Errorf("no such a watcher: %s", absname)
+		return &TailError{Path: absname, Op: "remove", Severity: SEVERITY_ERROR, Err: ErrorNotWatching}
 	}
+	if tail.refs > 1 { // views are still reading
+		tail.refs--
+		return nil
+	}
+	if tail.follow == FOLLOW_DESCRIPTOR {
+		return tw.removeDescriptor(tail)
+	}
+	tw.untrack(tail)
 	refcnt, found := tw.dirs[dirname]
 	if !found {
 		// FATAL
@@ -597,25 +1188,42 @@ func (tw *TailWatcher) Remove(pathname string) error {
 func (tw *TailWatcher) handleParentDisappear(dname string, errch chan<- error) {
 	glog.Errorf("parent directory disappeared: %s", dname)
 	tw.mu.Lock()
-	defer tw.mu.Unlock()
 
+	removed := make([]*TailName, 0)
 	for name, tail := range tw.tails {
 		if !strings.HasPrefix(name, dname) {
 			continue
 		}
+		if tail != nil && tail.follow == FOLLOW_DESCRIPTOR { // not in dname now
+			continue
+		}
 		delete(tw.tails, name)
 		if tail == nil {
 			continue
 		}
+		tw.untrack(tail)
 		tail.lines.Done()
+		removed = append(removed, tail)
+	}
+	for name := range tw.reloads {
+		if strings.HasPrefix(name, dname) {
+			delete(tw.reloads, name)
+		}
+	}
+	delete(tw.dirs, dname)
+	tw.mu.Unlock()
+
+	// subscribers may call TailWatcher methods
+	for _, tail := range removed {
 		if tail.file != nil {
 			if err := tail.file.Close(); err != nil {
 				if glog.V(1) {
 					glog.Infof("File.Close(): %s", err)
 				}
-				errch <- err
+				tail.sendError(errch, "close", SEVERITY_WARNING, err)
 			}
+			tail.file = nil
 		}
+		tail.emit(&TailEvent{Type: TAIL_DELETE, OldIno: tail.ino, OldOffset: tail.lastp})
 	}
-	delete(tw.dirs, dname)
 }
@@ -155,7 +155,7 @@ func TestFileLinesFilter(t *testing.T) {
 	// error handling
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -244,7 +244,7 @@ func TestTailWatcher(t *testing.T) {
 	// error handling
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -253,10 +253,21 @@ func TestTailWatcher(t *testing.T) {
 		t.Fatalf("failed to Add to TailWatcher: %s", err)
 	}
 
-	if _, err = tw.Add(filepath.Join(dir, "TailWatcher.1"), 5, nil, 5); err == nil {
-		t.Fatal("successed to Add duplicate name to TailWatcher")
+	// duplicate name returns a view
+	if _, err = tw.Add(filepath.Join(dir, "TailWatcher.1"), 5, nil, 5); err != nil {
+		t.Fatalf("failed to Add duplicate name to TailWatcher: %s", err)
+	}
+	if len(tw.tails) != 2 { // a file and its parent dir
+		t.Fatalf("len(tails) should be 2, but got: %d", len(tw.tails))
 	}
 
+	// the first Remove leaves the view watching
+	if err = tw.Remove(filepath.Join(dir, "TailWatcher.1")); err != nil {
+		t.Fatalf("failed to Remove from TailWatcher: %s", err)
+	}
+	if _, found := tw.tails[filepath.Join(dir, "TailWatcher.1")]; !found {
+		t.Fatal("the view is not watched after the first Remove")
+	}
 	if err = tw.Remove(filepath.Join(dir, "TailWatcher.1")); err != nil {
 		t.Fatalf("failed to Remove from TailWatcher: %s", err)
 	}
@@ -396,7 +407,7 @@ func TestTailRemoveCreate(t *testing.T) {
 	defer tw.Close()
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -471,7 +482,7 @@ func TestFilesInSameDir(t *testing.T) {
 	defer tw.Close()
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -552,7 +563,7 @@ func TestLookup(t *testing.T) {
 	defer tw.Close()
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -609,7 +620,7 @@ func TestLastZero(t *testing.T) {
 	defer tw.Close()
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -659,7 +670,7 @@ func TestParentDisappear(t *testing.T) {
 	defer tw.Close()
 	go func() {
 		for err := range tw.Error {
-			t.Fatalf("error received: %s", err)
+			t.Errorf("error received: %s", err)
 		}
 	}()
 
@@ -671,12 +682,20 @@ func TestParentDisappear(t *testing.T) {
 			}
 		}
 	}
+	// follow updates them on events
+	counts := func() (int, int) {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		return len(tw.tails), len(tw.dirs)
+	}
+
 	// checking
-	if len(tw.tails) != 1100 { // 100 dirs, each have 10 files
-		t.Fatalf("len(tails) should be 1100, but got: %d", len(tw.tails))
+	ntails, ndirs := counts()
+	if ntails != 1100 { // 100 dirs, each have 10 files
+		t.Fatalf("len(tails) should be 1100, but got: %d", ntails)
 	}
-	if len(tw.dirs) != 100 {
-		t.Fatalf("len(dirs) should be 100, but got: %d", len(tw.dirs))
+	if ndirs != 100 {
+		t.Fatalf("len(dirs) should be 100, but got: %d", ndirs)
 	}
 
 	// remove a few
@@ -691,10 +710,398 @@ func TestParentDisappear(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	// check again
-	if len(tw.tails) != 770 { // 70 dirs remainded
-		t.Fatalf("len(tails) should be 770, but got: %d", len(tw.tails))
+	ntails, ndirs = counts()
+	if ntails != 770 { // 70 dirs remainded
+		t.Fatalf("len(tails) should be 770, but got: %d", ntails)
+	}
+	if ndirs != 70 {
+		t.Fatalf("len(dirs) should be 70, but got: %d", ndirs)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	tmpfname := filepath.Join(dir, "TailWatcher.tmpfile")
+	if err := ioutil.WriteFile(fname, []byte("1\n2\n3\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.Add(fname, 5, nil, 5)
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	evch := make(chan *TailEvent, 16)
+	f := func(ev *TailEvent) { evch <- ev }
+	hooks := &Hooks{OnCreate: f, OnRotate: f, OnTruncate: f, OnDelete: f}
+	if err = tw.Subscribe(fname+"wrong", hooks); err == nil {
+		t.Fatalf("should not subscribe to wrong path")
+	}
+	if err = tw.Subscribe(fname, hooks); err != nil {
+		t.Fatalf("failed to Subscribe: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		tail.WaitNext()
+	}
+
+	expect := func(typ TailEventType) *TailEvent {
+		select {
+		case ev := <-evch:
+			if ev.Type != typ {
+				t.Fatalf("expect event %s, but got: %s", typ, ev)
+			}
+			if ev.Name != fname {
+				t.Fatalf("expect name %s, but got: %s", fname, ev.Name)
+			}
+			return ev
+		case <-time.After(1 * time.Second):
+			t.Fatalf("no event received, expect: %s", typ)
+		}
+		return nil
+	}
+
+	// truncate
+	if err = os.Truncate(fname, 0); err != nil {
+		t.Fatalf("failed to truncate testFile: %s", err)
+	}
+	ev := expect(TAIL_TRUNCATE)
+	if ev.OldOffset != 6 || ev.NewOffset != 0 {
+		t.Fatalf("invalid offsets: %d -> %d", ev.OldOffset, ev.NewOffset)
+	}
+	if err := ioutil.WriteFile(fname, []byte("a\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	if s := *tail.WaitNext(); s != "a" {
+		t.Fatalf("expect a but got: %s", s)
+	}
+
+	// rotate
+	if err = os.Rename(fname, tmpfname); err != nil {
+		t.Fatalf("failed to rename testfile: %s", err)
+	}
+	ev = expect(TAIL_DELETE)
+	oldIno := ev.OldIno
+	if ev.OldOffset != 2 {
+		t.Fatalf("expect offset 2, but got: %d", ev.OldOffset)
+	}
+	if err := ioutil.WriteFile(fname, []byte("b\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	ev = expect(TAIL_CREATE)
+	newIno := ev.NewIno
+	ev = expect(TAIL_ROTATE)
+	if ev.OldIno != oldIno || ev.NewIno != newIno || oldIno == newIno {
+		t.Fatalf("invalid inodes: %d -> %d", ev.OldIno, ev.NewIno)
+	}
+	if s := *tail.WaitNext(); s != "b" {
+		t.Fatalf("expect b but got: %s", s)
+	}
+
+	// unsubscribe
+	if err = tw.Unsubscribe(fname, hooks); err != nil {
+		t.Fatalf("failed to Unsubscribe: %s", err)
+	}
+	if err = tw.Unsubscribe(fname, hooks); err == nil {
+		t.Fatalf("should not unsubscribe twice")
+	}
+	if err = os.Remove(fname); err != nil {
+		t.Fatalf("failed to remove testfile: %s", err)
+	}
+	select {
+	case ev := <-evch:
+		t.Fatalf("unsubscribed but got: %s", ev)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("1\n2\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	tail, err := tw.AddOptions(fname, 10, nil, 10, &TailOptions{Markers: true})
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	reader := tail.Clone()
+
+	if err = os.Truncate(fname, 0); err != nil {
+		t.Fatalf("failed to truncate testFile: %s", err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("a\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+	if err = os.Remove(fname); err != nil {
+		t.Fatalf("failed to remove testfile: %s", err)
+	}
+
+	expects := []string{"1", "2", "file truncated", "a", "file removed, waiting"}
+	for i, expect := range expects {
+		line := tail.WaitNextLine()
+		if line == nil {
+			t.Fatalf("expect %s, but got nil", expect)
+		}
+		if line.String() != expect {
+			t.Fatalf("expect %s, but got: %s", expect, line)
+		}
+		if line.IsMarker() != (i == 2 || i == 4) {
+			t.Fatalf("invalid marker: %s", line)
+		}
+	}
+
+	// WaitNext skips markers
+	s := ""
+	for i := 0; i < 3; i++ {
+		s += *reader.WaitNext()
+	}
+	if s != "12a" {
+		t.Fatalf("expect 12a, but got: %s", s)
+	}
+	if p := reader.Next(); p != nil {
+		t.Fatalf("expect nil, but got: %s", *p)
+	}
+}
+
+func TestViews(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	testFile, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("failed to create testFile: %s", err)
+	}
+	defer testFile.Close()
+	if _, err := testFile.WriteString("ok 1\nerror 2\nok 3\n"); err != nil {
+		t.Fatalf("failed to WriteString to testFile: %s", err)
+	}
+	filterFile := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(filterFile, []byte("^error\n"), 0666); err != nil {
+		t.Fatalf("failed to write filter: %s", err)
+	}
+	errors, err := RegexpFilter(filterFile)
+	if err != nil {
+		t.Fatalf("failed to create filter: %s", err)
+	}
+	noerrors, err := RegexpFilter("!" + filterFile)
+	if err != nil {
+		t.Fatalf("failed to create filter: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	all, err := tw.Add(fname, 10, nil, 10)
+	if err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	errview, err := tw.Add(fname, 10, errors, 10)
+	if err != nil {
+		t.Fatalf("failed to Add view to TailWatcher: %s", err)
+	}
+	okview, err := tw.Add(fname, 10, noerrors, 10)
+	if err != nil {
+		t.Fatalf("failed to Add view to TailWatcher: %s", err)
+	}
+	if _, err = testFile.WriteString("error 4\nok 5\n"); err != nil {
+		t.Fatalf("failed to WriteString to testFile: %s", err)
+	}
+
+	var s string
+	for i := 0; i < 5; i++ {
+		s += *all.WaitNext() + ","
+	}
+	if s != "ok 1,error 2,ok 3,error 4,ok 5," {
+		t.Fatalf("unexpected lines: %s", s)
+	}
+	s = ""
+	for i := 0; i < 2; i++ {
+		s += *errview.WaitNext() + ","
+	}
+	if s != "error 2,error 4," {
+		t.Fatalf("unexpected lines: %s", s)
+	}
+	s = ""
+	for p := okview.WaitNext(); p != nil; p = okview.Next() {
+		s += *p + ","
+	}
+	if s != "ok 1,ok 3,ok 5," {
+		t.Fatalf("unexpected lines: %s", s)
+	}
+
+	// clone carries the view
+	s = ""
+	clone := errview.Clone()
+	for p := clone.Next(); p != nil; p = clone.Next() {
+		s += *p + ","
+	}
+	if s != "error 2,error 4," {
+		t.Fatalf("unexpected lines: %s", s)
+	}
+}
+
+func TestViewStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	t.Logf("tmpdir: %s", dir)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	testFile, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("failed to create testFile: %s", err)
 	}
-	if len(tw.dirs) != 70 {
-		t.Fatalf("len(dirs) should be 70, but got: %d", len(tw.dirs))
+	defer testFile.Close()
+	if _, err := testFile.WriteString("ok 1\n"); err != nil {
+		t.Fatalf("failed to WriteString to testFile: %s", err)
+	}
+	errors, err := NewFilter("substr:error")
+	if err != nil {
+		t.Fatalf("failed to create filter: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+	go func() {
+		for err := range tw.Error {
+			t.Errorf("error received: %s", err)
+		}
+	}()
+
+	if _, err := tw.Add(fname, 10, nil, 10); err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	view, err := tw.Add(fname, 10, errors, 10)
+	if err != nil {
+		t.Fatalf("failed to Add view to TailWatcher: %s", err)
+	}
+	clones := []Tail{view.Clone(), view.Clone()}
+	if _, err = testFile.WriteString("error 2\nok 3\nerror 4\n"); err != nil {
+		t.Fatalf("failed to WriteString to testFile: %s", err)
+	}
+
+	// lines ingested after the view is set are counted once by any readers
+	for _, tail := range append(clones, view) {
+		var s string
+		for i := 0; i < 2; i++ {
+			s += *tail.WaitNext() + ","
+		}
+		if s != "error 2,error 4," {
+			t.Fatalf("unexpected lines: %s", s)
+		}
+	}
+	expect := "substr:error 3/1, substr:error 3/2/1"
+	if s := statsString(FilterStatsOf(errors)); s != expect {
+		t.Fatalf("expect: %s, but got: %s", expect, s)
+	}
+}
+
+func TestViewIngestDiffers(t *testing.T) {
+	dir, err := ioutil.TempDir("", TMP_PREFIX)
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "TailWatcher.testfile")
+	if err := ioutil.WriteFile(fname, []byte("ok 1\nerror 2\n"), 0666); err != nil {
+		t.Fatalf("failed to write testFile: %s", err)
+	}
+
+	tw, err := NewTailWatcher()
+	if err != nil {
+		t.Fatalf("could not create TailWatcher: %s", err)
+	}
+	defer tw.Close()
+
+	if _, err := tw.Add(fname, 10, substrFilter("error"), 10); err != nil {
+		t.Fatalf("failed to Add to TailWatcher: %s", err)
+	}
+	for _, c := range []struct {
+		maxline, lines int
+		filter         Filter
+		opts           *TailOptions
+	}{
+		{10, 10, substrFilter("ok"), nil}, // would be stacked on the first filter
+		{10, 10, nil, nil},
+		{20, 10, substrFilter("error"), nil},
+		{10, 5, substrFilter("error"), nil},
+		{10, 10, substrFilter("error"), &TailOptions{Markers: true}},
+	} {
+		_, err := tw.AddOptions(fname, c.maxline, c.filter, c.lines, c.opts)
+		if terr, ok := err.(*TailError); !ok || terr.Err != ErrorIngestDiffers {
+			t.Fatalf("%d %d %v %v: expect ErrorIngestDiffers, but got: %v", c.maxline, c.lines, c.filter, c.opts, err)
+		}
+	}
+	view, err := tw.Add(fname, 10, substrFilter("error"), 10)
+	if err != nil {
+		t.Fatalf("failed to Add the same filter: %s", err)
+	}
+	if line := view.Next(); line == nil || *line != "error 2" {
+		t.Fatalf("expect error 2, but got: %v", line)
+	}
+
+	// streams are the same
+	if _, err := tw.AddReader("stream", strings.NewReader(""), 8, nil); err != nil {
+		t.Fatalf("AddReader: %s", err)
+	}
+	if _, err := tw.AddReader("stream", strings.NewReader(""), 16, nil); err == nil {
+		t.Fatalf("expect ErrorIngestDiffers for the stream")
+	}
+	if _, err := tw.AddReader("stream", strings.NewReader(""), 8, substrFilter("x")); err != nil {
+		t.Fatalf("AddReader view: %s", err)
+	}
+	for i, expect := range []bool{true, true, false} {
+		if got := tw.removeStream("stream"); got != expect {
+			t.Fatalf("removeStream %d: expect %v, but got: %v", i+1, expect, got)
+		}
 	}
 }